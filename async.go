@@ -0,0 +1,293 @@
+package ginche
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// DropPolicy defines what AsyncAdapter does with writes when its queue is full.
+type DropPolicy int
+
+const (
+	// DropNewest drops the write that does not fit into the queue.
+	DropNewest DropPolicy = iota
+	// DropOldest drops the oldest queued write to make room for the new one.
+	DropOldest
+	// BlockWhenFull waits until the write fits into the queue.
+	BlockWhenFull
+)
+
+// AsyncOptions is the options for the async adapter
+// QueueSize is the number of writes that can wait to be written, defaults to 1024
+// Workers is the number of goroutines writing to the adapter, defaults to 4
+// DropPolicy is what happens to writes when the queue is full, defaults to DropNewest
+type AsyncOptions struct {
+	QueueSize  int
+	Workers    int
+	DropPolicy DropPolicy
+}
+
+// AsyncStats reports the state of the write queue of an AsyncAdapter.
+// Queued is the number of writes waiting to be written, the other fields are totals.
+// Writes discarded by Delete or FlushAll are neither written nor dropped.
+type AsyncStats struct {
+	Queued  int64
+	Written uint64
+	Dropped uint64
+}
+
+// AsyncAdapter writes items to the wrapped adapter in the background,
+// so slow writes never hold up a response.
+// Writes of the same key are written in order by the same worker.
+// Reads, Delete and FlushAll go to the wrapped adapter directly,
+// and queued writes issued before a Delete or FlushAll are discarded.
+type AsyncAdapter struct {
+	adapter CacheAdapter
+	options AsyncOptions
+	queues  []chan asyncWrite
+
+	mu         sync.Mutex
+	seq        uint64
+	pending    int64
+	flushedAt  uint64
+	tombstones map[string]uint64
+	// writing is held for reading while a write is checked and written,
+	// Delete and FlushAll take it to wait for writes that are already running.
+	writing sync.RWMutex
+
+	// idle is closed once no writes are queued, see Flush
+	idle chan struct{}
+	// sending is held for reading while writes are sent to the queues,
+	// Close takes it before closing them.
+	sending   sync.RWMutex
+	written   uint64
+	dropped   uint64
+	closed    bool
+	closeOnce sync.Once
+	closeErr  error
+	wg        sync.WaitGroup
+}
+
+// asyncWrite is a queued Set.
+type asyncWrite struct {
+	seq    uint64
+	key    string
+	value  interface{}
+	config []*ItemConfig
+}
+
+// NewAsyncAdapter starts the workers writing to adapter.
+// If options is nil, the defaults of AsyncOptions are used.
+// The adapter takes ownership of the wrapped adapter and closes it on Close.
+func NewAsyncAdapter(adapter CacheAdapter, options *AsyncOptions) *AsyncAdapter {
+	a := &AsyncAdapter{adapter: adapter, tombstones: make(map[string]uint64)}
+	if options != nil {
+		a.options = *options
+	}
+	if a.options.QueueSize <= 0 {
+		a.options.QueueSize = 1024
+	}
+	if a.options.Workers <= 0 {
+		a.options.Workers = 4
+	}
+	size := ceilDiv(int64(a.options.QueueSize), int64(a.options.Workers))
+	a.queues = make([]chan asyncWrite, a.options.Workers)
+	for i := range a.queues {
+		a.queues[i] = make(chan asyncWrite, size)
+		a.wg.Add(1)
+		go func(queue chan asyncWrite) {
+			defer a.wg.Done()
+			a.work(queue)
+		}(a.queues[i])
+	}
+	return a
+}
+
+// Set queues the item to be written. If the queue is full, the DropPolicy decides
+// which write is dropped. Writes after Close are dropped.
+func (a *AsyncAdapter) Set(key *string, value interface{}, config ...*ItemConfig) {
+	a.sending.RLock()
+	defer a.sending.RUnlock()
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		atomic.AddUint64(&a.dropped, 1)
+		return
+	}
+	a.seq++
+	a.pending++
+	w := asyncWrite{seq: a.seq, key: *key, value: value, config: config}
+	a.mu.Unlock()
+
+	queue := a.queue(w.key)
+	switch a.options.DropPolicy {
+	case BlockWhenFull:
+		queue <- w
+		return
+	case DropOldest:
+		for {
+			select {
+			case queue <- w:
+				return
+			default:
+			}
+			select {
+			case <-queue:
+				a.done(true)
+			default:
+			}
+		}
+	default:
+		select {
+		case queue <- w:
+		default:
+			a.done(true)
+		}
+	}
+}
+
+func (a *AsyncAdapter) Get(key string) (interface{}, bool) {
+	return a.adapter.Get(key)
+}
+
+// Delete deletes the item and discards queued writes of the key.
+func (a *AsyncAdapter) Delete(key string) {
+	a.mu.Lock()
+	a.seq++
+	if a.pending > 0 {
+		a.tombstones[key] = a.seq
+	}
+	a.mu.Unlock()
+	a.waitForRunningWrites()
+	a.adapter.Delete(key)
+}
+
+func (a *AsyncAdapter) Find(pattern string) []string {
+	return a.adapter.Find(pattern)
+}
+
+// FlushAll deletes all items and discards all queued writes.
+func (a *AsyncAdapter) FlushAll() {
+	a.mu.Lock()
+	a.seq++
+	a.flushedAt = a.seq
+	a.tombstones = make(map[string]uint64)
+	a.mu.Unlock()
+	a.waitForRunningWrites()
+	a.adapter.FlushAll()
+}
+
+// Ping checks the wrapped adapter if it implements Pinger.
+func (a *AsyncAdapter) Ping(ctx context.Context) error {
+	if p, ok := a.adapter.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// Stats returns the depth of the queue and the number of written and dropped writes.
+func (a *AsyncAdapter) Stats() AsyncStats {
+	a.mu.Lock()
+	queued := a.pending
+	a.mu.Unlock()
+	return AsyncStats{
+		Queued:  queued,
+		Written: atomic.LoadUint64(&a.written),
+		Dropped: atomic.LoadUint64(&a.dropped),
+	}
+}
+
+// Flush waits until the queue is empty or the context is done.
+func (a *AsyncAdapter) Flush(ctx context.Context) error {
+	a.mu.Lock()
+	if a.pending == 0 {
+		a.mu.Unlock()
+		return nil
+	}
+	if a.idle == nil {
+		a.idle = make(chan struct{})
+	}
+	idle := a.idle
+	a.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close writes all queued items, stops the workers and closes the wrapped adapter.
+// It is safe to call Close multiple times.
+func (a *AsyncAdapter) Close() error {
+	a.closeOnce.Do(func() {
+		a.sending.Lock()
+		a.mu.Lock()
+		a.closed = true
+		a.mu.Unlock()
+		a.sending.Unlock()
+		for _, queue := range a.queues {
+			close(queue)
+		}
+		a.wg.Wait()
+		a.closeErr = a.adapter.Close()
+	})
+	return a.closeErr
+}
+
+// work writes the items of the queue until it is closed.
+func (a *AsyncAdapter) work(queue chan asyncWrite) {
+	for w := range queue {
+		a.writing.RLock()
+		stale := a.stale(w)
+		if !stale {
+			a.adapter.Set(&w.key, w.value, w.config...)
+		}
+		a.writing.RUnlock()
+		if !stale {
+			atomic.AddUint64(&a.written, 1)
+		}
+		a.done(false)
+	}
+}
+
+// stale reports whether the write was issued before a Delete of its key or a FlushAll.
+func (a *AsyncAdapter) stale(w asyncWrite) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return w.seq < a.flushedAt || w.seq < a.tombstones[w.key]
+}
+
+// done removes a write from the queue depth, counting it as dropped if it was.
+// Tombstones are only needed while writes are queued, waiting Flush calls return once none are.
+func (a *AsyncAdapter) done(dropped bool) {
+	if dropped {
+		atomic.AddUint64(&a.dropped, 1)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending--
+	if a.pending > 0 {
+		return
+	}
+	if len(a.tombstones) > 0 {
+		a.tombstones = make(map[string]uint64)
+	}
+	if a.idle != nil {
+		close(a.idle)
+		a.idle = nil
+	}
+}
+
+// waitForRunningWrites waits for writes that were checked before a Delete or FlushAll to finish.
+func (a *AsyncAdapter) waitForRunningWrites() {
+	a.writing.Lock()
+	a.writing.Unlock()
+}
+
+// queue returns the queue of the worker writing the key.
+func (a *AsyncAdapter) queue(key string) chan asyncWrite {
+	h, _ := hashKey(key)
+	return a.queues[h%uint64(len(a.queues))]
+}
@@ -0,0 +1,193 @@
+package ginche
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+// gatedCache blocks writes until the gate is opened.
+type gatedCache struct {
+	*InMemoryCache
+	gate chan struct{}
+}
+
+func (g *gatedCache) Set(key *string, value interface{}, config ...*ItemConfig) {
+	<-g.gate
+	g.InMemoryCache.Set(key, value, config...)
+}
+
+type AsyncSuite struct {
+	suite.Suite
+	cache *gatedCache
+}
+
+func (s *AsyncSuite) SetupTest() {
+	s.cache = &gatedCache{InMemoryCache: NewInMemoryCache().(*InMemoryCache), gate: make(chan struct{})}
+}
+
+func (s *AsyncSuite) open() {
+	close(s.cache.gate)
+}
+
+// waitRunning waits until the worker took the first write from the queue.
+func (s *AsyncSuite) waitRunning(store *AsyncAdapter) {
+	s.Eventually(func() bool {
+		return len(store.queues[0]) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func (s *AsyncSuite) TestWritesInBackground() {
+	store := NewAsyncAdapter(s.cache, nil)
+	defer store.Close()
+	// Set returns while the write is blocked
+	store.Set(String("key"), "value")
+	_, ok := store.Get("key")
+	s.False(ok)
+	s.Equal(int64(1), store.Stats().Queued)
+
+	s.open()
+	s.NoError(store.Flush(context.Background()))
+	d, ok := store.Get("key")
+	s.True(ok)
+	s.Equal("value", d)
+	s.Equal(AsyncStats{Queued: 0, Written: 1}, store.Stats())
+}
+
+func (s *AsyncSuite) TestDropNewest() {
+	store := NewAsyncAdapter(s.cache, &AsyncOptions{QueueSize: 2, Workers: 1})
+	defer store.Close()
+	store.Set(String("key0"), 0)
+	s.waitRunning(store)
+	for i := 1; i < 5; i++ {
+		store.Set(String(fmt.Sprintf("key%d", i)), i)
+	}
+	// One write is running, two are queued
+	s.Equal(uint64(2), store.Stats().Dropped)
+
+	s.open()
+	s.NoError(store.Flush(context.Background()))
+	s.ElementsMatch([]string{"key0", "key1", "key2"}, store.Find("*"))
+	s.Equal(AsyncStats{Queued: 0, Written: 3, Dropped: 2}, store.Stats())
+}
+
+func (s *AsyncSuite) TestDropOldest() {
+	store := NewAsyncAdapter(s.cache, &AsyncOptions{QueueSize: 2, Workers: 1, DropPolicy: DropOldest})
+	defer store.Close()
+	store.Set(String("key0"), 0)
+	s.waitRunning(store)
+	for i := 1; i < 5; i++ {
+		store.Set(String(fmt.Sprintf("key%d", i)), i)
+	}
+	s.Equal(uint64(2), store.Stats().Dropped)
+
+	s.open()
+	s.NoError(store.Flush(context.Background()))
+	s.ElementsMatch([]string{"key0", "key3", "key4"}, store.Find("*"))
+}
+
+func (s *AsyncSuite) TestBlockWhenFull() {
+	store := NewAsyncAdapter(s.cache, &AsyncOptions{QueueSize: 1, Workers: 1, DropPolicy: BlockWhenFull})
+	defer store.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			store.Set(String(fmt.Sprintf("key%d", i)), i)
+		}
+	}()
+	select {
+	case <-done:
+		s.Fail("Set did not block")
+	case <-time.After(20 * time.Millisecond):
+	}
+	s.open()
+	<-done
+	s.NoError(store.Flush(context.Background()))
+	s.Len(store.Find("*"), 5)
+	s.Equal(uint64(0), store.Stats().Dropped)
+}
+
+func (s *AsyncSuite) TestDeleteDiscardsQueuedWrites() {
+	store := NewAsyncAdapter(s.cache, &AsyncOptions{Workers: 1})
+	defer store.Close()
+	store.Set(String("running"), 0)
+	s.waitRunning(store)
+	store.Set(String("key"), "old")
+	store.Set(String("other"), 1)
+
+	// Delete waits for the running write
+	deleted := make(chan struct{})
+	go func() {
+		defer close(deleted)
+		store.Delete("key")
+	}()
+	s.Eventually(func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return store.tombstones["key"] > 0
+	}, time.Second, time.Millisecond)
+	store.Set(String("key"), "new")
+	s.open()
+	<-deleted
+	s.NoError(store.Flush(context.Background()))
+	s.ElementsMatch([]string{"running", "key", "other"}, store.Find("*"))
+	d, _ := store.Get("key")
+	s.Equal("new", d)
+	s.Empty(store.tombstones)
+}
+
+func (s *AsyncSuite) TestFlushAllDiscardsQueuedWrites() {
+	store := NewAsyncAdapter(s.cache, &AsyncOptions{Workers: 1})
+	defer store.Close()
+	store.Set(String("running"), 0)
+	s.waitRunning(store)
+	store.Set(String("queued"), 1)
+
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		store.FlushAll()
+	}()
+	s.Eventually(func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return store.flushedAt > 0
+	}, time.Second, time.Millisecond)
+	store.Set(String("key"), "new")
+	s.open()
+	<-flushed
+	s.NoError(store.Flush(context.Background()))
+	s.Equal([]string{"key"}, store.Find("*"))
+	s.Equal(uint64(2), store.Stats().Written)
+}
+
+func (s *AsyncSuite) TestFlushTimeout() {
+	store := NewAsyncAdapter(s.cache, nil)
+	store.Set(String("key"), "value")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	s.ErrorIs(store.Flush(ctx), context.DeadlineExceeded)
+	s.open()
+	s.NoError(store.Close())
+}
+
+func (s *AsyncSuite) TestCloseWritesQueuedItems() {
+	store := NewAsyncAdapter(s.cache, nil)
+	for i := 0; i < 100; i++ {
+		store.Set(String(fmt.Sprintf("key%d", i)), i)
+	}
+	go s.open()
+	s.NoError(store.Close())
+	s.Equal(AsyncStats{Written: 100}, store.Stats())
+	s.NoError(store.Close())
+
+	store.Set(String("late"), 0)
+	s.Equal(uint64(1), store.Stats().Dropped)
+}
+
+func TestAsyncSuite(t *testing.T) {
+	suite.Run(t, new(AsyncSuite))
+}
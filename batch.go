@@ -0,0 +1,64 @@
+package ginche
+
+import "time"
+
+// BatchAdapter is implemented by adapters that can read and write several keys at once,
+// e.g. with a single round trip to remote storage.
+// GetMulti returns the values of the keys that were found, missing keys are left out.
+// Use the GetMulti, SetMulti and DeleteMulti functions to batch operations on any adapter.
+type BatchAdapter interface {
+	GetMulti(keys []string) map[string]interface{}
+	SetMulti(items map[string]interface{}, config ...*ItemConfig)
+	DeleteMulti(keys []string)
+}
+
+// Entry is a cached value with its remaining TTL.
+type Entry struct {
+	Value interface{}
+	TTL   time.Duration
+}
+
+// BatchTTLGetter is implemented by adapters that can report the remaining TTL of several items at once.
+type BatchTTLGetter interface {
+	GetMultiWithTTL(keys []string) map[string]Entry
+}
+
+// GetMulti returns the values of the keys found in the adapter.
+// It falls back to a Get per key if the adapter does not implement BatchAdapter.
+func GetMulti(adapter CacheAdapter, keys []string) map[string]interface{} {
+	if batch, ok := adapter.(BatchAdapter); ok {
+		return batch.GetMulti(keys)
+	}
+	values := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if value, ok := adapter.Get(key); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// SetMulti stores the items in the adapter.
+// It falls back to a Set per item if the adapter does not implement BatchAdapter.
+func SetMulti(adapter CacheAdapter, items map[string]interface{}, config ...*ItemConfig) {
+	if batch, ok := adapter.(BatchAdapter); ok {
+		batch.SetMulti(items, config...)
+		return
+	}
+	for key, value := range items {
+		key := key
+		adapter.Set(&key, value, config...)
+	}
+}
+
+// DeleteMulti deletes the keys from the adapter.
+// It falls back to a Delete per key if the adapter does not implement BatchAdapter.
+func DeleteMulti(adapter CacheAdapter, keys []string) {
+	if batch, ok := adapter.(BatchAdapter); ok {
+		batch.DeleteMulti(keys)
+		return
+	}
+	for _, key := range keys {
+		adapter.Delete(key)
+	}
+}
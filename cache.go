@@ -1,36 +1,106 @@
 package ginche
 
 import (
-	"regexp"
+	"context"
+	"io"
+	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // InMemoryCache is a thread-safe in-memory cache.
 // It is safe to use concurrently.
 // It will automatically cleanup expired items.
+// If MaxEntries or MaxBytes is set, it will evict items chosen by
+// the configured EvictionPolicy once the limit is reached.
+// Items are spread over lock-striped shards, so operations on
+// different keys rarely contend for the same lock.
 type InMemoryCache struct {
-	items           *sync.Map
+	shards          []*cacheShard
+	limits          *cacheLimits
+	evictMu         sync.Mutex
+	shardShift      uint
 	ttl             time.Duration
 	cleanupInterval time.Duration
+	nextCleanup     int64
+	wakeCleanup     chan struct{}
+	done            chan struct{}
+	closeOnce       sync.Once
+	wg              sync.WaitGroup
+	sizeFunc        func(key string, value interface{}) int64
+	sized           bool
+	onEvict         func(key string, value interface{})
+	snapshotPath    string
+	closeErr        error
 }
 
 // CacheConfig is used to configure a cache.
 // If CleanupInterval or TTL is nil, it will default to 1 minute.
+// If MaxEntries or MaxBytes is zero, the cache is unbounded by that limit.
+// Items larger than MaxItemBytes (if set) or MaxBytes are rejected.
+// SizeFunc overrides how item sizes are calculated, see SizeOf for the default.
+// EvictionPolicy selects which items are evicted, it defaults to EvictionLRU.
+// OnEvict is called for every item evicted to make room for a new one.
+// Shards is the number of lock-striped shards, rounded up to a power of two
+// and reduced to at most MaxEntries. If it is zero, it is chosen so that every shard
+// holds a meaningful share of the limits. MaxEntries and MaxBytes apply to the whole cache,
+// victims are evicted from the shard holding the most entries or bytes.
+// Namespace isolates keys of remote adapters sharing the same storage,
+// the in-memory cache ignores it.
+// InvalidationBus keeps local caches of remote adapters coherent between instances,
+// adapters create their own bus if it is nil. A shared bus is not closed by adapters.
+// If PingTimeout is set, constructors of remote adapters ping their storage
+// and fail if it does not answer in time.
+// HashResponses makes RedisAdapter store cached HTTP responses as hashes with separate
+// status, headers, metadata and raw body fields, see ResponseMetaGetter.
+// Compression makes remote adapters compress values of at least CompressionThreshold
+// bytes, 1024 by default. Values written without compression can still be read.
+// VerifyIntegrity makes remote adapters store a checksum with every entry and verify it on read,
+// IntegritySecret replaces the checksum by an HMAC and enables verification. Entries failing
+// verification are misses, they are deleted and passed to OnIntegrityError.
+// If SnapshotFile is set, the in-memory cache is restored from the file when it is created
+// and written to it on Close, so a restarted process starts with a warm cache.
 type CacheConfig struct {
-	TTL             *time.Duration
-	CleanupInterval *time.Duration
+	TTL                  *time.Duration
+	CleanupInterval      *time.Duration
+	EvictionPolicy       EvictionPolicy
+	MaxEntries           int
+	MaxBytes             int64
+	MaxItemBytes         int64
+	SizeFunc             func(key string, value interface{}) int64
+	OnEvict              func(key string, value interface{})
+	Shards               int
+	Namespace            string
+	InvalidationBus      InvalidationBus
+	PingTimeout          time.Duration
+	HashResponses        bool
+	Compression          Compression
+	CompressionThreshold int
+	VerifyIntegrity      bool
+	IntegritySecret      []byte
+	OnIntegrityError     func(key string, err error)
+	SnapshotFile         string
 }
 
 // Item is an item in the cache.
-// It contains the value and the time it expires.
+// It contains the value, its accounted size and the time it expires.
 type Item struct {
+	key       string
 	value     interface{}
+	size      int64
 	expiresAt time.Time
+	heapIndex int
+	prev      *Item
+	next      *Item
+	segment   segment
+	candidate bool
 }
 
 // ItemConfig is used to configure an item.
 // If TTL is nil, it will use the cache's default TTL.
+// A TTL of 0 means the item never expires in the Redis, memcached, disk and SQL adapters,
+// while InMemoryCache treats it as already expired.
 type ItemConfig struct {
 	TTL *time.Duration
 }
@@ -50,98 +120,409 @@ func NewInMemoryCache(config ...CacheConfig) CacheAdapter {
 	if config != nil && config[0].CleanupInterval != nil {
 		cleanupInterval = config[0].CleanupInterval
 	}
-	if config != nil && ttl != config[0].TTL {
+	if config != nil && config[0].TTL != nil {
 		ttl = config[0].TTL
 	}
+	var conf CacheConfig
+	if config != nil {
+		conf = config[0]
+	}
 	c := &InMemoryCache{
-		items:           &sync.Map{},
 		ttl:             *ttl,
 		cleanupInterval: *cleanupInterval,
+		wakeCleanup:     make(chan struct{}, 1),
+		done:            make(chan struct{}),
+		sizeFunc:        conf.SizeFunc,
+		sized:           conf.MaxBytes > 0 || conf.MaxItemBytes > 0,
+		onEvict:         conf.OnEvict,
+		snapshotPath:    conf.SnapshotFile,
+	}
+	if c.sizeFunc == nil {
+		c.sizeFunc = SizeOf
+	}
+	c.nextCleanup = time.Now().Add(c.cleanupInterval).UnixNano()
+
+	c.limits = &cacheLimits{
+		maxEntries:   int64(conf.MaxEntries),
+		maxBytes:     conf.MaxBytes,
+		maxItemBytes: conf.MaxItemBytes,
+	}
+	shards := shardCount(conf)
+	c.shards = make([]*cacheShard, shards)
+	for shards > 1 {
+		shards >>= 1
+		c.shardShift++
+	}
+	c.shardShift = 64 - c.shardShift
+	for i := range c.shards {
+		c.shards[i] = newCacheShard(conf, c.limits, len(c.shards))
+	}
+	if c.snapshotPath != "" {
+		if err := c.restoreFile(c.snapshotPath); err != nil {
+			log.Printf("Error restoring cache snapshot %s: %v", c.snapshotPath, err)
+		}
 	}
 
-	go c.cleanup()
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.cleanup()
+	}()
 	return c
 }
 
+// shardCount returns the number of shards for the config, a power of two.
+// There are never more shards than MaxEntries. Unless set explicitly, it is reduced
+// while a shard would hold fewer than minShardEntries entries or minShardBytes bytes,
+// or less than an item of the maximum size.
+func shardCount(conf CacheConfig) int {
+	n := 1
+	if conf.Shards > 0 {
+		for n < conf.Shards {
+			n <<= 1
+		}
+		for n > 1 && conf.MaxEntries > 0 && n > conf.MaxEntries {
+			n >>= 1
+		}
+		return n
+	}
+	n = defaultShards
+	minBytes := int64(minShardBytes)
+	if conf.MaxItemBytes > minBytes {
+		minBytes = conf.MaxItemBytes
+	}
+	for n > 1 && ((conf.MaxEntries > 0 && conf.MaxEntries/n < minShardEntries) ||
+		(conf.MaxBytes > 0 && conf.MaxBytes/int64(n) < minBytes)) {
+		n >>= 1
+	}
+	return n
+}
+
+// shard returns the shard holding the key.
+// It uses the high bits of the hash, the low bits are used by the TinyLFU sketch.
+func (c *InMemoryCache) shard(key string) *cacheShard {
+	h, _ := hashKey(key)
+	return c.shards[h>>c.shardShift&uint64(len(c.shards)-1)]
+}
+
 // Set adds an item to the cache with the given key and value.
 // If config is not nil, it will use the TTL from the config.
 // Otherwise, it will use the cache's default TTL.
+// If the cache is full, items chosen by the eviction policy are evicted.
+// If the item is too large to fit, it is rejected and any previous value
+// stored under the same key is removed.
 func (c *InMemoryCache) Set(key *string, value interface{}, config ...*ItemConfig) {
-	var expiresAt time.Time
+	expiresAt := c.expiresAt(config...)
+
+	var size int64
+	if c.sized {
+		size = c.sizeFunc(*key, value)
+	}
+
+	c.shard(*key).set(*key, value, size, expiresAt)
+	c.itemsAdded(expiresAt)
+}
+
+// SetMulti adds the items to the cache, locking every shard once.
+// It works like Set for every item.
+func (c *InMemoryCache) SetMulti(items map[string]interface{}, config ...*ItemConfig) {
+	expiresAt := c.expiresAt(config...)
+	byShard := make(map[*cacheShard][]*Item)
+	for key, value := range items {
+		var size int64
+		if c.sized {
+			size = c.sizeFunc(key, value)
+		}
+		s := c.shard(key)
+		byShard[s] = append(byShard[s], &Item{key: key, value: value, size: size, expiresAt: expiresAt})
+	}
+	for s, items := range byShard {
+		s.setMulti(items)
+	}
+	c.itemsAdded(expiresAt)
+}
+
+// expiresAt returns the expiration time of an item set with the config.
+func (c *InMemoryCache) expiresAt(config ...*ItemConfig) time.Time {
 	if config != nil {
 		if config[0].TTL != nil {
-			expiresAt = time.Now().Add(*config[0].TTL)
+			return time.Now().Add(*config[0].TTL)
+		}
+		return time.Time{}
+	}
+	return time.Now().Add(c.ttl)
+}
+
+// itemsAdded wakes the cleanup if the new items expire before its next run,
+// evicts items while the cache exceeds its limits and reports the evicted items.
+func (c *InMemoryCache) itemsAdded(expiresAt time.Time) {
+	if expiresAt.UnixNano() < atomic.LoadInt64(&c.nextCleanup) {
+		select {
+		case c.wakeCleanup <- struct{}{}:
+		default:
+		}
+	}
+	evicted := c.evict()
+	if c.onEvict != nil {
+		for _, item := range evicted {
+			c.onEvict(item.key, item.value)
 		}
-	} else {
-		expiresAt = time.Now().Add(c.ttl)
 	}
+}
 
-	c.items.Store(*key, &Item{value: value, expiresAt: expiresAt})
+// evict removes items chosen by the eviction policies until the cache fits its limits
+// and returns them. Evictions are serialized, so concurrent writers never evict
+// more items than needed.
+func (c *InMemoryCache) evict() []*Item {
+	if entries, bytes := c.limits.overflows(); !entries && !bytes {
+		return nil
+	}
+	c.evictMu.Lock()
+	defer c.evictMu.Unlock()
+	var evicted []*Item
+	for {
+		entries, bytes := c.limits.overflows()
+		if !entries && !bytes {
+			return evicted
+		}
+		s := c.evictionShard(entries)
+		if s == nil {
+			return evicted
+		}
+		if item := s.evict(); item != nil {
+			evicted = append(evicted, item)
+		}
+	}
+}
+
+// evictionShard returns the shard holding the most entries, or the most bytes
+// if only the byte limit is exceeded. Shards holding a single item are only chosen
+// if all others are empty, so a large new item does not evict itself.
+// It returns nil if the cache is empty.
+func (c *InMemoryCache) evictionShard(byEntries bool) *cacheShard {
+	var best *cacheShard
+	var bestSize int64
+	bestSingle := false
+	for _, s := range c.shards {
+		n := atomic.LoadInt64(&s.entries)
+		if n == 0 {
+			continue
+		}
+		size := n
+		if !byEntries {
+			size = atomic.LoadInt64(&s.bytes)
+		}
+		single := n == 1
+		if best == nil || (bestSingle && !single) || (single == bestSingle && size > bestSize) {
+			best, bestSize, bestSingle = s, size, single
+		}
+	}
+	return best
 }
 
-// Find returns all keys that match the given pattern.
+// Find returns all keys that match the given glob pattern.
+// Exact keys are looked up directly and "prefix*" patterns skip the glob matcher.
 func (c *InMemoryCache) Find(pattern string) []string {
 	var keys []string
-	r := regexp.MustCompile(pattern)
+	g := compileGlob(pattern)
+	now := time.Now()
 
-	c.items.Range(func(k, v interface{}) bool {
-		if v.(*Item).expiresAt.After(time.Now()) {
-			if r.MatchString(k.(string)) {
-				keys = append(keys, k.(string))
-			}
+	if g.kind == globExact {
+		if c.shard(g.literal).exists(g.literal, now) {
+			keys = append(keys, g.literal)
 		}
-		return true
-	})
+		return keys
+	}
+	for _, s := range c.shards {
+		keys, _ = s.appendMatching(keys, g, now)
+	}
 	return keys
 }
 
+// Scan returns keys matching the glob pattern shard by shard.
+// Every call visits whole shards until at least count items were examined,
+// so it may return more keys than count. Keys present during the whole
+// iteration are returned exactly once.
+func (c *InMemoryCache) Scan(ctx context.Context, pattern string, cursor uint64, count int64) ([]string, uint64, error) {
+	var keys []string
+	g := compileGlob(pattern)
+	now := time.Now()
+
+	var examined int64
+	for i := cursor; i < uint64(len(c.shards)); i++ {
+		if err := ctx.Err(); err != nil {
+			return keys, i, err
+		}
+		var n int
+		keys, n = c.shards[i].appendMatching(keys, g, now)
+		examined += int64(n)
+		if examined >= count && i+1 < uint64(len(c.shards)) {
+			return keys, i + 1, nil
+		}
+	}
+	return keys, 0, nil
+}
+
 // Get returns the value of the item with the given key.
 // If the item does not exist or has expired, it will return nil and false.
 func (c *InMemoryCache) Get(key string) (interface{}, bool) {
-	itemInterface, ok := c.items.Load(key)
+	value, _, ok := c.shard(key).get(key)
+	return value, ok
+}
+
+// GetWithTTL works like Get and also returns the remaining TTL of the item.
+func (c *InMemoryCache) GetWithTTL(key string) (interface{}, time.Duration, bool) {
+	value, expiresAt, ok := c.shard(key).get(key)
 	if !ok {
-		return nil, ok
+		return nil, 0, false
+	}
+	return value, time.Until(expiresAt), true
+}
+
+// GetMulti returns the values of the keys found in the cache, locking every shard once.
+func (c *InMemoryCache) GetMulti(keys []string) map[string]interface{} {
+	entries := c.GetMultiWithTTL(keys)
+	values := make(map[string]interface{}, len(entries))
+	for key, entry := range entries {
+		values[key] = entry.Value
 	}
-	item := itemInterface.(*Item)
-	if time.Now().After(item.expiresAt) {
-		c.items.Delete(key)
-		return nil, false
+	return values
+}
+
+// GetMultiWithTTL works like GetMulti and also returns the remaining TTL of the items.
+func (c *InMemoryCache) GetMultiWithTTL(keys []string) map[string]Entry {
+	entries := make(map[string]Entry, len(keys))
+	now := time.Now()
+	for s, keys := range c.byShard(keys) {
+		s.getMulti(keys, entries, now)
+	}
+	return entries
+}
+
+// DeleteMulti deletes the keys from the cache, locking every shard once.
+func (c *InMemoryCache) DeleteMulti(keys []string) {
+	for s, keys := range c.byShard(keys) {
+		s.deleteMulti(keys)
 	}
-	return item.value, true
+}
+
+// byShard groups the keys by the shard holding them.
+func (c *InMemoryCache) byShard(keys []string) map[*cacheShard][]string {
+	groups := make(map[*cacheShard][]string)
+	for _, key := range keys {
+		s := c.shard(key)
+		groups[s] = append(groups[s], key)
+	}
+	return groups
+}
+
+// Len returns the number of items in the cache,
+// including expired items that have not been cleaned up yet.
+func (c *InMemoryCache) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		n += len(s.items)
+		s.mu.Unlock()
+	}
+	return n
 }
 
 // FlushAll deletes all items from the cache.
 func (c *InMemoryCache) FlushAll() {
-	c.items = &sync.Map{}
+	for _, s := range c.shards {
+		s.flush()
+	}
 }
 
 // cleanup deletes all expired items from the cache.
-// It is called every cleanupInterval.
+// It runs when the next item expires, but at least every cleanupInterval.
+// Runs are at least expiryResolution apart, and each run only visits
+// the items that have actually expired.
+// It returns once the cache is closed.
 func (c *InMemoryCache) cleanup() {
+	timer := time.NewTimer(c.cleanupInterval)
+	defer timer.Stop()
 	for {
-		time.Sleep(c.cleanupInterval)
-		c.items.Range(func(k, v interface{}) bool {
-			if time.Now().After(v.(*Item).expiresAt) {
-				c.items.Delete(k)
+		select {
+		case <-c.done:
+			return
+		case <-timer.C:
+		case <-c.wakeCleanup:
+			if !timer.Stop() {
+				<-timer.C
 			}
-			return true
-		})
+		}
+		wait := c.deleteExpired(time.Now())
+		timer.Reset(wait)
+	}
+}
+
+// deleteExpired deletes expired items from all shards and returns how long
+// to wait until the next run.
+func (c *InMemoryCache) deleteExpired(now time.Time) time.Duration {
+	next := now.Add(c.cleanupInterval)
+	for _, s := range c.shards {
+		if at, ok := s.deleteExpired(now); ok && at.Before(next) {
+			next = at
+		}
+	}
+	wait := next.Sub(now)
+	if wait < expiryResolution {
+		wait = expiryResolution
 	}
+	atomic.StoreInt64(&c.nextCleanup, now.Add(wait).UnixNano())
+	return wait
 }
 
 // Delete deletes the item with the given key from the cache.
 func (c *InMemoryCache) Delete(key string) {
-	c.items.Delete(key)
+	c.shard(key).delete(key)
+}
+
+// Close stops the background cleanup and waits for it to return.
+// If SnapshotFile is set, it writes the snapshot and returns its error.
+// The cache can still be used afterwards, but expired items are only
+// removed when they are accessed. It is safe to call Close multiple times.
+func (c *InMemoryCache) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+		if c.snapshotPath != "" {
+			c.closeErr = c.snapshotFile(c.snapshotPath)
+		}
+	})
+	c.wg.Wait()
+	return c.closeErr
 }
 
+// Ping always succeeds, the in-memory cache has no connection to check.
+func (c *InMemoryCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+// CacheAdapter is the storage used by the middleware.
+// Find returns keys matching a glob pattern, see pattern.go for the syntax.
+// Close releases background goroutines and connections held by the adapter.
 type CacheAdapter interface {
 	Set(key *string, value interface{}, config ...*ItemConfig)
 	Get(key string) (interface{}, bool)
 	Delete(key string)
 	Find(pattern string) []string
 	FlushAll()
+	io.Closer
 }
 
-// TODO: Implement adapter interface for external storages
-// TODO: Implement Redis storage
-// TODO: Implement Memcached storage
+// TTLGetter is implemented by adapters that can report the remaining TTL of an item.
+type TTLGetter interface {
+	GetWithTTL(key string) (interface{}, time.Duration, bool)
+}
+
+// Scanner is implemented by adapters that can walk their keys in batches,
+// which is safer than Find for large keyspaces.
+// Iteration starts with cursor 0 and ends when the returned cursor is 0.
+// Patterns are globs, like in Find. Count is a hint for the batch size.
+// Keys present during the whole iteration are returned at least once.
+type Scanner interface {
+	Scan(ctx context.Context, pattern string, cursor uint64, count int64) (keys []string, next uint64, err error)
+}
@@ -8,6 +8,7 @@ import (
 func BenchmarkCache_Set(b *testing.B) {
 	// Create a new cache with a TTL of 1 minute
 	c := NewInMemoryCache()
+	defer c.Close()
 
 	// Run the Set method b.N times
 	for i := 0; i < b.N; i++ {
@@ -20,17 +21,39 @@ func BenchmarkCache_Set(b *testing.B) {
 func BenchmarkCache_Get(b *testing.B) {
 	// Create a new cache with a TTL of 1 minute
 	c := NewInMemoryCache()
+	defer c.Close()
 
 	// Add some items to the cache
+	keys := make([]string, b.N)
 	for i := 0; i < b.N; i++ {
-		key := String(fmt.Sprintf("key%d", i))
+		keys[i] = fmt.Sprintf("key%d", i)
 		value := fmt.Sprintf("value%d", i)
-		c.Set(key, value)
+		c.Set(&keys[i], value)
 	}
+	b.ResetTimer()
 
 	// Run the Get method b.N times
 	for i := 0; i < b.N; i++ {
-		key := fmt.Sprintf("key%d", i)
-		c.Get(key)
+		c.Get(keys[i])
+	}
+}
+
+func BenchmarkCache_GetParallel(b *testing.B) {
+	c := NewInMemoryCache()
+	defer c.Close()
+
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		c.Set(&keys[i], fmt.Sprintf("value%d", i))
 	}
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.Get(keys[i%len(keys)])
+			i++
+		}
+	})
 }
@@ -1,7 +1,14 @@
 package ginche
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"github.com/stretchr/testify/suite"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -20,6 +27,7 @@ func (s *CacheSuite) SetupTest() {
 }
 
 func (s *CacheSuite) TearDownTest() {
+	s.NoError(s.cache.Close())
 	s.cache = nil
 }
 
@@ -81,6 +89,295 @@ func (s *CacheSuite) TestCleanup() {
 	s.Nil(d)
 }
 
+func (s *CacheSuite) TestLRUEviction() {
+	var evicted []string
+	cache := NewInMemoryCache(CacheConfig{
+		MaxEntries: 2,
+		OnEvict: func(key string, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+	cache.Set(String("a"), 1)
+	cache.Set(String("b"), 2)
+	// Touch "a" so that "b" becomes the least recently used item
+	_, ok := cache.Get("a")
+	s.True(ok)
+	cache.Set(String("c"), 3)
+
+	s.Equal([]string{"b"}, evicted)
+	_, ok = cache.Get("b")
+	s.False(ok)
+	d, ok := cache.Get("a")
+	s.True(ok)
+	s.Equal(1, d)
+	d, ok = cache.Get("c")
+	s.True(ok)
+	s.Equal(3, d)
+}
+
+func (s *CacheSuite) TestLRUUpdateDoesNotEvict() {
+	evictions := 0
+	cache := NewInMemoryCache(CacheConfig{
+		MaxEntries: 2,
+		OnEvict: func(key string, value interface{}) {
+			evictions++
+		},
+	})
+	cache.Set(String("a"), 1)
+	cache.Set(String("b"), 2)
+	cache.Set(String("a"), 3)
+	s.Equal(0, evictions)
+	d, ok := cache.Get("a")
+	s.True(ok)
+	s.Equal(3, d)
+}
+
+func (s *CacheSuite) TestLRUConcurrentAccess() {
+	cache := NewInMemoryCache(CacheConfig{MaxEntries: 64})
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("key%d", (g*1000+i)%128)
+				cache.Set(&key, i)
+				cache.Get(key)
+			}
+		}(g)
+	}
+	wg.Wait()
+	s.LessOrEqual(cache.(*InMemoryCache).Len(), 64)
+}
+
+func (s *CacheSuite) TestMaxBytesEviction() {
+	var evicted []string
+	cache := NewInMemoryCache(CacheConfig{
+		MaxBytes: 10,
+		OnEvict: func(key string, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+	cache.Set(String("a"), "1234")
+	cache.Set(String("b"), "1234")
+	s.Equal(int64(10), cache.(*InMemoryCache).shards[0].bytes)
+	cache.Set(String("c"), "12")
+	s.Equal([]string{"a"}, evicted)
+	s.Equal(int64(8), cache.(*InMemoryCache).shards[0].bytes)
+
+	// Growing an existing item evicts others to stay under the budget
+	cache.Set(String("c"), "12345678")
+	s.Equal([]string{"a", "b"}, evicted)
+	s.Equal(int64(9), cache.(*InMemoryCache).shards[0].bytes)
+}
+
+func (s *CacheSuite) TestMaxItemBytesRejects() {
+	cache := NewInMemoryCache(CacheConfig{
+		MaxItemBytes: 5,
+		SizeFunc: func(key string, value interface{}) int64 {
+			return int64(len(value.(string)))
+		},
+	})
+	cache.Set(String("a"), "12345")
+	d, ok := cache.Get("a")
+	s.True(ok)
+	s.Equal("12345", d)
+
+	// Oversized value is rejected and the stale one is dropped
+	cache.Set(String("a"), "123456")
+	_, ok = cache.Get("a")
+	s.False(ok)
+	s.Equal(int64(0), cache.(*InMemoryCache).shards[0].bytes)
+}
+
+func (s *CacheSuite) TestConcurrentFlushAll() {
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("key%d", g*1000+i)
+				s.cache.Set(&key, i)
+				s.cache.Get(key)
+			}
+		}(g)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				s.cache.FlushAll()
+			}
+		}()
+	}
+	wg.Wait()
+	s.cache.FlushAll()
+	s.Equal(0, s.cache.(*InMemoryCache).Len())
+}
+
+func (s *CacheSuite) TestFind() {
+	for _, key := range []string{"/users/1", "/users/2", "/posts/1"} {
+		s.cache.Set(String(key), key)
+	}
+	s.ElementsMatch([]string{"/users/1", "/users/2"}, s.cache.Find("/users/*"))
+	s.ElementsMatch([]string{"/users/1", "/posts/1"}, s.cache.Find("*/1"))
+	s.ElementsMatch([]string{"/users/2"}, s.cache.Find("/users/2"))
+	s.Empty(s.cache.Find("/users"))
+	s.Empty(s.cache.Find("/comments/*"))
+}
+
+func (s *CacheSuite) TestScan() {
+	cache := NewInMemoryCache(CacheConfig{Shards: 8})
+	defer cache.Close()
+	for i := 0; i < 100; i++ {
+		cache.Set(String(fmt.Sprintf("/users/%d", i)), i)
+	}
+	cache.Set(String("/posts/1"), 1)
+
+	var keys []string
+	var cursor uint64
+	calls := 0
+	for {
+		batch, next, err := cache.(Scanner).Scan(context.Background(), "/users/*", cursor, 1)
+		s.NoError(err)
+		keys = append(keys, batch...)
+		calls++
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	// Every call visits at least one of the 8 shards
+	s.Greater(calls, 1)
+	s.LessOrEqual(calls, 8)
+	s.Len(keys, 100)
+	s.ElementsMatch(cache.Find("/users/*"), keys)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, next, err := cache.(Scanner).Scan(ctx, "*", 3, 1)
+	s.ErrorIs(err, context.Canceled)
+	s.Equal(uint64(3), next)
+}
+
+func (s *CacheSuite) TestClose() {
+	cache := NewInMemoryCache(CacheConfig{CleanupInterval: Duration(10 * time.Millisecond)})
+	s.NoError(cache.Close())
+	s.NoError(cache.Close())
+
+	// Cleanup is stopped, the expired item is only removed on access
+	cache.Set(String("key"), 1, &ItemConfig{TTL: Duration(time.Millisecond)})
+	time.Sleep(100 * time.Millisecond)
+	s.Equal(1, cache.(*InMemoryCache).Len())
+	_, ok := cache.Get("key")
+	s.False(ok)
+	s.Equal(0, cache.(*InMemoryCache).Len())
+}
+
+func (s *CacheSuite) TestMulti() {
+	cache := s.cache.(*InMemoryCache)
+	items := make(map[string]interface{})
+	var keys []string
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		items[key] = i
+		keys = append(keys, key)
+	}
+	cache.SetMulti(items)
+	s.Equal(100, cache.Len())
+
+	values := cache.GetMulti(append(keys, "missing"))
+	s.Equal(items, values)
+	entries := cache.GetMultiWithTTL(keys[:1])
+	s.InDelta(time.Minute, entries["key0"].TTL, float64(time.Second))
+
+	cache.SetMulti(map[string]interface{}{"short": 1}, &ItemConfig{TTL: Duration(time.Millisecond)})
+	time.Sleep(5 * time.Millisecond)
+	s.Empty(cache.GetMulti([]string{"short"}))
+
+	cache.DeleteMulti(keys[:50])
+	s.Len(cache.GetMulti(keys), 50)
+}
+
+func (s *CacheSuite) TestSetMultiEvicts() {
+	var evicted []string
+	cache := NewInMemoryCache(CacheConfig{
+		MaxEntries: 2,
+		OnEvict: func(key string, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+	defer cache.Close()
+	cache.Set(String("old"), 0)
+	cache.(BatchAdapter).SetMulti(map[string]interface{}{"a": 1, "b": 2})
+	s.Equal([]string{"old"}, evicted)
+	s.Len(GetMulti(cache, []string{"old", "a", "b"}), 2)
+}
+
+func (s *CacheSuite) TestSnapshotRestore() {
+	cache := s.cache.(*InMemoryCache)
+	response := &httpCacheItem{Status: 202, Headers: http.Header{"Etag": {`"v1"`}}, Data: "body"}
+	cache.Set(String("/response"), response)
+	cache.Set(String("/value"), map[string]interface{}{"a": "b"}, &ItemConfig{TTL: Duration(time.Hour)})
+	cache.Set(String("/expiring"), 1, &ItemConfig{TTL: Duration(50 * time.Millisecond)})
+	cache.Set(String("/unsupported"), make(chan int))
+
+	var buf bytes.Buffer
+	s.NoError(cache.Snapshot(&buf))
+	s.Equal(4, strings.Count(buf.String(), "\n"), "the header and one line per encodable item")
+	time.Sleep(60 * time.Millisecond)
+
+	restored := NewInMemoryCache(CacheConfig{MaxEntries: 10}).(*InMemoryCache)
+	defer restored.Close()
+	s.NoError(restored.Restore(&buf))
+	s.Equal(2, restored.Len(), "expired and unencodable items are skipped")
+	d, ok := restored.Get("/response")
+	s.True(ok)
+	s.Equal(response, d)
+	d, ttl, ok := restored.GetWithTTL("/value")
+	s.True(ok)
+	s.Equal(map[string]interface{}{"a": "b"}, d)
+	s.InDelta(time.Hour, ttl, float64(time.Second), "the remaining TTL is kept")
+}
+
+func (s *CacheSuite) TestRestoreErrors() {
+	cache := s.cache.(*InMemoryCache)
+	s.Error(cache.Restore(strings.NewReader("")))
+	s.Error(cache.Restore(strings.NewReader(`{"ginche_snapshot":2}`)))
+
+	expiresAt := time.Now().Add(time.Minute).UnixMilli()
+	err := cache.Restore(strings.NewReader(fmt.Sprintf("{\"ginche_snapshot\":1}\n{\"k\":\"a\",\"e\":%d,\"v\":1}\n{broken", expiresAt)))
+	s.Error(err)
+	d, ok := cache.Get("a")
+	s.True(ok, "items restored before the error are kept")
+	s.Equal(float64(1), d)
+}
+
+func (s *CacheSuite) TestSnapshotFile() {
+	path := filepath.Join(s.T().TempDir(), "cache.snapshot")
+	cache := NewInMemoryCache(CacheConfig{SnapshotFile: path})
+	cache.Set(String("key"), "value")
+	s.NoError(cache.Close())
+	s.FileExists(path)
+
+	cache = NewInMemoryCache(CacheConfig{SnapshotFile: path})
+	d, ok := cache.Get("key")
+	s.True(ok)
+	s.Equal("value", d)
+	cache.Delete("key")
+	s.NoError(cache.Close())
+
+	cache = NewInMemoryCache(CacheConfig{SnapshotFile: path})
+	defer cache.Close()
+	_, ok = cache.Get("key")
+	s.False(ok)
+	matches, _ := filepath.Glob(path + ".tmp-*")
+	s.Empty(matches, "temporary files are renamed")
+
+	// Snapshot errors are returned by Close
+	broken := NewInMemoryCache(CacheConfig{SnapshotFile: filepath.Join(filepath.Dir(path), "missing", "cache.snapshot")})
+	s.Error(broken.Close())
+}
+
 func TestCacheSuite(t *testing.T) {
 	suite.Run(t, new(CacheSuite))
 }
@@ -0,0 +1,156 @@
+package ginche
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// Compression selects how remote adapters compress stored values.
+type Compression int
+
+const (
+	// CompressionNone stores values as they are.
+	CompressionNone Compression = iota
+	// CompressionGzip compresses values with gzip.
+	CompressionGzip
+	// CompressionFlate compresses values with raw deflate, which is a few bytes smaller than gzip.
+	CompressionFlate
+)
+
+// defaultCompressionThreshold is the smallest value compressed when CacheConfig.CompressionThreshold is not set
+const defaultCompressionThreshold = 1024
+
+// Header bytes of compressed values. Serialized values are JSON objects starting with '{',
+// so values without a header, written before compression was enabled, can still be read.
+// Header bytes of integrity checks are in integrity.go.
+const (
+	compressedGzip  byte = 0x01
+	compressedFlate byte = 0x02
+)
+
+var errUnknownEncoding = errors.New("ginche: unknown value encoding")
+
+// CompressionStats reports how well stored values compress.
+// Values and Compressed count values written and values stored compressed,
+// BytesIn and BytesOut are their total sizes before and after compression.
+// Ratio is BytesOut divided by BytesIn, e.g. 0.25 if values take a quarter of their size.
+type CompressionStats struct {
+	Values     uint64
+	Compressed uint64
+	BytesIn    uint64
+	BytesOut   uint64
+	Ratio      float64
+}
+
+// valueCodec compresses values above a threshold and decompresses values by their header byte.
+// It also adds and verifies integrity checks, see seal and open.
+type valueCodec struct {
+	compression Compression
+	threshold   int
+	verify      bool
+	secret      []byte
+	onError     func(key string, err error)
+	values      uint64
+	compressed  uint64
+	bytesIn     uint64
+	bytesOut    uint64
+}
+
+func newValueCodec(conf CacheConfig) *valueCodec {
+	c := &valueCodec{
+		compression: conf.Compression,
+		threshold:   conf.CompressionThreshold,
+		verify:      conf.VerifyIntegrity || conf.IntegritySecret != nil,
+		secret:      conf.IntegritySecret,
+		onError:     conf.OnIntegrityError,
+	}
+	if c.threshold <= 0 {
+		c.threshold = defaultCompressionThreshold
+	}
+	return c
+}
+
+// encode compresses the value if it is large enough and compression makes it smaller.
+// It reports whether the value was compressed, compressed values start with a header byte.
+func (c *valueCodec) encode(value []byte) ([]byte, bool) {
+	out := value
+	if c.compression != CompressionNone && len(value) >= c.threshold {
+		if compressed, err := compress(c.compression, value); err == nil && len(compressed) < len(value) {
+			out = compressed
+			atomic.AddUint64(&c.compressed, 1)
+		}
+	}
+	atomic.AddUint64(&c.values, 1)
+	atomic.AddUint64(&c.bytesIn, uint64(len(value)))
+	atomic.AddUint64(&c.bytesOut, uint64(len(out)))
+	return out, len(out) != len(value)
+}
+
+// decode decompresses the value if it starts with a header byte.
+// Values of any compression can be read, whatever the codec is configured to write.
+func (c *valueCodec) decode(value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return value, nil
+	}
+	var r io.ReadCloser
+	var err error
+	switch value[0] {
+	case compressedGzip:
+		r, err = gzip.NewReader(bytes.NewReader(value[1:]))
+	case compressedFlate:
+		r = flate.NewReader(bytes.NewReader(value[1:]))
+	case '{', '[', '"':
+		return value, nil
+	default:
+		return nil, errUnknownEncoding
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (c *valueCodec) stats() CompressionStats {
+	stats := CompressionStats{
+		Values:     atomic.LoadUint64(&c.values),
+		Compressed: atomic.LoadUint64(&c.compressed),
+		BytesIn:    atomic.LoadUint64(&c.bytesIn),
+		BytesOut:   atomic.LoadUint64(&c.bytesOut),
+	}
+	if stats.BytesIn > 0 {
+		stats.Ratio = float64(stats.BytesOut) / float64(stats.BytesIn)
+	}
+	return stats
+}
+
+// compress returns the value compressed with the header byte of the compression.
+func compress(compression Compression, value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch compression {
+	case CompressionGzip:
+		buf.WriteByte(compressedGzip)
+		w = gzip.NewWriter(&buf)
+	case CompressionFlate:
+		buf.WriteByte(compressedFlate)
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		w = fw
+	default:
+		return nil, errUnknownEncoding
+	}
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,65 @@
+package ginche
+
+import (
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestValueCodec(t *testing.T) {
+	large := []byte(`{"Data":"` + strings.Repeat("compressible ", 200) + `"}`)
+	random := make([]byte, 2048)
+	_, _ = rand.Read(random)
+	incompressible := append(append([]byte(`{"Data":"`), random...), '"', '}')
+
+	tests := []struct {
+		name        string
+		compression Compression
+		threshold   int
+		value       []byte
+		compressed  bool
+		header      byte
+	}{
+		{"none", CompressionNone, 0, large, false, '{'},
+		{"gzip", CompressionGzip, 0, large, true, compressedGzip},
+		{"flate", CompressionFlate, 0, large, true, compressedFlate},
+		{"below threshold", CompressionGzip, 0, []byte(`{"Data":"small"}`), false, '{'},
+		{"custom threshold", CompressionGzip, len(large) + 1, large, false, '{'},
+		{"incompressible", CompressionFlate, 0, incompressible, false, '{'},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec := newValueCodec(CacheConfig{Compression: tt.compression, CompressionThreshold: tt.threshold})
+			encoded, compressed := codec.encode(tt.value)
+			assert.Equal(t, tt.compressed, compressed)
+			assert.Equal(t, tt.header, encoded[0])
+			if tt.compressed {
+				assert.Less(t, len(encoded), len(tt.value))
+			}
+			decoded, err := codec.decode(encoded)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.value, decoded)
+
+			stats := codec.stats()
+			assert.Equal(t, uint64(1), stats.Values)
+			assert.Equal(t, uint64(len(tt.value)), stats.BytesIn)
+			assert.Equal(t, uint64(len(encoded)), stats.BytesOut)
+			assert.InDelta(t, float64(len(encoded))/float64(len(tt.value)), stats.Ratio, 1e-9)
+		})
+	}
+}
+
+func TestValueCodecDecodesAnyCompression(t *testing.T) {
+	value := []byte(`{"Data":"` + strings.Repeat("a", 4096) + `"}`)
+	gzipped, _ := newValueCodec(CacheConfig{Compression: CompressionGzip}).encode(value)
+	plain := newValueCodec(CacheConfig{})
+	decoded, err := plain.decode(gzipped)
+	assert.NoError(t, err)
+	assert.Equal(t, value, decoded)
+
+	_, err = plain.decode([]byte{0x7f, 'x'})
+	assert.ErrorIs(t, err, errUnknownEncoding)
+	_, err = plain.decode([]byte{compressedGzip, 'x'})
+	assert.Error(t, err)
+}
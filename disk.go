@@ -0,0 +1,455 @@
+package ginche
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// diskMagic starts every entry file, the last byte is the version of the format
+	diskMagic = "gnc1"
+	// diskHeaderSize is the size of the magic, the expiry time and the key length preceding the key
+	diskHeaderSize = len(diskMagic) + 8 + 4
+	// diskTempPrefix starts the names of files that are still being written
+	diskTempPrefix = ".tmp-"
+)
+
+var errDiskBadEntry = errors.New("ginche: malformed disk cache entry")
+
+// DiskAdapter stores every item in its own file, so large values stay out of the Go heap
+// until they are read. Files are spread over two levels of subdirectories named after
+// the hash of their key, and written to a temporary file renamed into place,
+// so readers never see partially written entries.
+// An in-memory index of keys, sizes and expiry times is rebuilt from the files on startup.
+// CacheConfig.MaxBytes limits the total size of the files, least recently used entries
+// are deleted to make room. Expired entries are deleted every CleanupInterval.
+// Compression and integrity checks of CacheConfig apply like for remote adapters.
+// The directory must not be shared by several adapters.
+type DiskAdapter struct {
+	dir          string
+	ttl          time.Duration
+	maxBytes     int64
+	maxItemBytes int64
+	codec        *valueCodec
+
+	mu      sync.Mutex
+	entries map[string]*diskEntry
+	// lru orders entries from the most to the least recently used
+	lru  *list.List
+	size int64
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// diskEntry is an indexed entry file.
+type diskEntry struct {
+	key       string
+	path      string
+	size      int64
+	expiresAt time.Time
+	element   *list.Element
+}
+
+// NewDiskAdapter creates an adapter storing items under dir, creating it if needed,
+// and indexes the entries already stored there.
+// If TTL is nil, it will default to 5 minutes, CleanupInterval defaults to 1 minute.
+func NewDiskAdapter(dir string, config ...CacheConfig) (CacheAdapter, error) {
+	var conf CacheConfig
+	if config != nil {
+		conf = config[0]
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	d := &DiskAdapter{
+		dir:          dir,
+		ttl:          5 * time.Minute,
+		maxBytes:     conf.MaxBytes,
+		maxItemBytes: conf.MaxItemBytes,
+		codec:        newValueCodec(conf),
+		entries:      make(map[string]*diskEntry),
+		lru:          list.New(),
+		done:         make(chan struct{}),
+	}
+	if conf.TTL != nil {
+		d.ttl = *conf.TTL
+	}
+	cleanupInterval := time.Minute
+	if conf.CleanupInterval != nil {
+		cleanupInterval = *conf.CleanupInterval
+	}
+	if err := d.rebuild(); err != nil {
+		return nil, err
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.cleanup(cleanupInterval)
+	}()
+	return d, nil
+}
+
+// Set writes the item to a temporary file and renames it into place.
+// Items larger than MaxItemBytes or MaxBytes are rejected and replace the stored item by a miss.
+func (d *DiskAdapter) Set(key *string, value interface{}, config ...*ItemConfig) {
+	ttl := d.ttl
+	if config != nil && config[0].TTL != nil {
+		ttl = *config[0].TTL
+	}
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+	val, err := json.Marshal(item{Data: value})
+	if err != nil {
+		return
+	}
+	data := d.codec.seal(*key, val)
+	size := int64(diskHeaderSize + len(*key) + len(data))
+	if (d.maxItemBytes > 0 && size > d.maxItemBytes) || (d.maxBytes > 0 && size > d.maxBytes) {
+		d.Delete(*key)
+		return
+	}
+
+	path := d.path(*key)
+	tmp, err := d.writeTemp(filepath.Dir(path), *key, expiresAt, data)
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return
+	}
+	if old, ok := d.entries[*key]; ok {
+		d.unindex(old)
+	}
+	d.index(&diskEntry{key: *key, path: path, size: size, expiresAt: expiresAt})
+	d.evict()
+}
+
+// writeTemp writes the entry to a new temporary file in dir and returns its path.
+func (d *DiskAdapter) writeTemp(dir, key string, expiresAt time.Time, data []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, diskTempPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	header := make([]byte, diskHeaderSize, diskHeaderSize+len(key))
+	copy(header, diskMagic)
+	if !expiresAt.IsZero() {
+		binary.BigEndian.PutUint64(header[len(diskMagic):], uint64(expiresAt.UnixNano()))
+	}
+	binary.BigEndian.PutUint32(header[len(diskMagic)+8:], uint32(len(key)))
+	header = append(header, key...)
+	_, err = f.Write(header)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func (d *DiskAdapter) Get(key string) (interface{}, bool) {
+	value, _, ok := d.GetWithTTL(key)
+	return value, ok
+}
+
+// GetWithTTL works like Get and also returns the remaining TTL of the item,
+// zero if it does not expire.
+func (d *DiskAdapter) GetWithTTL(key string) (interface{}, time.Duration, bool) {
+	now := time.Now()
+	d.mu.Lock()
+	entry, ok := d.entries[key]
+	if ok && entry.expired(now) {
+		d.remove(entry)
+		ok = false
+	}
+	if !ok {
+		d.mu.Unlock()
+		return nil, 0, false
+	}
+	d.lru.MoveToFront(entry.element)
+	d.mu.Unlock()
+
+	value, err := d.read(entry)
+	if err != nil {
+		d.mu.Lock()
+		if d.entries[key] == entry {
+			d.remove(entry)
+		}
+		d.mu.Unlock()
+		if !errors.Is(err, fs.ErrNotExist) {
+			d.codec.corrupted(key, err)
+		}
+		return nil, 0, false
+	}
+	var ttl time.Duration
+	if !entry.expiresAt.IsZero() {
+		ttl = entry.expiresAt.Sub(now)
+	}
+	return value, ttl, true
+}
+
+// read returns the value stored in the file of the entry.
+func (d *DiskAdapter) read(entry *diskEntry) (interface{}, error) {
+	b, err := os.ReadFile(entry.path)
+	if err != nil {
+		return nil, err
+	}
+	key, _, n, err := parseDiskHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	if key != entry.key {
+		return nil, errDiskBadEntry
+	}
+	val, err := d.codec.open(key, b[n:])
+	if err != nil {
+		return nil, err
+	}
+	var data item
+	if err := json.Unmarshal(val, &data); err != nil {
+		return nil, err
+	}
+	return data.Data, nil
+}
+
+func (d *DiskAdapter) Delete(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if entry, ok := d.entries[key]; ok {
+		d.remove(entry)
+	}
+}
+
+// Find returns all keys of unexpired entries that match the given glob pattern.
+func (d *DiskAdapter) Find(pattern string) []string {
+	g := compileGlob(pattern)
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	keys := []string{}
+	for key, entry := range d.entries {
+		if g.match(key) && !entry.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// FlushAll deletes all indexed entries.
+func (d *DiskAdapter) FlushAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, entry := range d.entries {
+		d.remove(entry)
+	}
+}
+
+// Size returns the total size of the entry files in bytes.
+func (d *DiskAdapter) Size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.size
+}
+
+// Ping checks that the directory still exists.
+func (d *DiskAdapter) Ping(ctx context.Context) error {
+	info, err := os.Stat(d.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("ginche: %s is not a directory", d.dir)
+	}
+	return nil
+}
+
+// Close stops the background cleanup and waits for it to return.
+// Entries are kept on disk and indexed again by the next adapter using the directory.
+// It is safe to call Close multiple times.
+func (d *DiskAdapter) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.done)
+	})
+	d.wg.Wait()
+	return nil
+}
+
+// cleanup deletes expired entries every interval until the adapter is closed.
+func (d *DiskAdapter) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case now := <-ticker.C:
+			d.deleteExpired(now)
+		}
+	}
+}
+
+func (d *DiskAdapter) deleteExpired(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, entry := range d.entries {
+		if entry.expired(now) {
+			d.remove(entry)
+		}
+	}
+}
+
+// rebuild indexes the entry files in the directory, least recently modified last.
+// Expired or malformed entries and leftover temporary files are deleted.
+func (d *DiskAdapter) rebuild() error {
+	type found struct {
+		entry   *diskEntry
+		modTime time.Time
+	}
+	var files []found
+	now := time.Now()
+	err := filepath.WalkDir(d.dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil || de.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(de.Name(), diskTempPrefix) {
+			_ = os.Remove(path)
+			return nil
+		}
+		entry, modTime, err := d.readEntry(path)
+		if err != nil || entry.expired(now) {
+			_ = os.Remove(path)
+			return nil
+		}
+		files = append(files, found{entry: entry, modTime: modTime})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, f := range files {
+		d.index(f.entry)
+	}
+	d.evict()
+	return nil
+}
+
+// readEntry reads the header of an entry file.
+func (d *DiskAdapter) readEntry(path string) (*diskEntry, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	header := make([]byte, diskHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, time.Time{}, errDiskBadEntry
+	}
+	keyLen := int64(binary.BigEndian.Uint32(header[len(diskMagic)+8:]))
+	if keyLen > info.Size()-int64(diskHeaderSize) {
+		return nil, time.Time{}, errDiskBadEntry
+	}
+	header = append(header, make([]byte, keyLen)...)
+	if _, err := io.ReadFull(f, header[diskHeaderSize:]); err != nil {
+		return nil, time.Time{}, errDiskBadEntry
+	}
+	key, expiresAt, _, err := parseDiskHeader(header)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if d.path(key) != path {
+		return nil, time.Time{}, errDiskBadEntry
+	}
+	return &diskEntry{key: key, path: path, size: info.Size(), expiresAt: expiresAt}, info.ModTime(), nil
+}
+
+// parseDiskHeader returns the key and expiry time of an entry and the offset of its value.
+func parseDiskHeader(b []byte) (string, time.Time, int, error) {
+	if len(b) < diskHeaderSize || string(b[:len(diskMagic)]) != diskMagic {
+		return "", time.Time{}, 0, errDiskBadEntry
+	}
+	var expiresAt time.Time
+	if ns := int64(binary.BigEndian.Uint64(b[len(diskMagic):])); ns != 0 {
+		expiresAt = time.Unix(0, ns)
+	}
+	end := diskHeaderSize + int(binary.BigEndian.Uint32(b[len(diskMagic)+8:]))
+	if end > len(b) {
+		return "", time.Time{}, 0, errDiskBadEntry
+	}
+	return string(b[diskHeaderSize:end]), expiresAt, end, nil
+}
+
+// path returns the file of the key, dir/ab/cd/abcd... where abcd... is the SHA-256 of the key.
+func (d *DiskAdapter) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(d.dir, name[:2], name[2:4], name)
+}
+
+// index adds the entry as the most recently used one. It must be called with the lock held.
+func (d *DiskAdapter) index(entry *diskEntry) {
+	entry.element = d.lru.PushFront(entry)
+	d.entries[entry.key] = entry
+	d.size += entry.size
+}
+
+// unindex drops the entry from the index without deleting its file.
+func (d *DiskAdapter) unindex(entry *diskEntry) {
+	d.lru.Remove(entry.element)
+	delete(d.entries, entry.key)
+	d.size -= entry.size
+}
+
+// remove drops the entry from the index and deletes its file.
+func (d *DiskAdapter) remove(entry *diskEntry) {
+	d.unindex(entry)
+	_ = os.Remove(entry.path)
+}
+
+// evict deletes the least recently used entries until the total size fits MaxBytes.
+func (d *DiskAdapter) evict() {
+	for d.maxBytes > 0 && d.size > d.maxBytes {
+		d.remove(d.lru.Back().Value.(*diskEntry))
+	}
+}
+
+func (e *diskEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
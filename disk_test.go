@@ -0,0 +1,196 @@
+package ginche
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type DiskSuite struct {
+	suite.Suite
+	dir   string
+	store CacheAdapter
+}
+
+func (s *DiskSuite) SetupTest() {
+	s.dir = s.T().TempDir()
+	var err error
+	s.store, err = NewDiskAdapter(s.dir)
+	s.Require().NoError(err)
+}
+
+func (s *DiskSuite) TearDownTest() {
+	s.NoError(s.store.Close())
+}
+
+// files returns the names of all files under the directory.
+func (s *DiskSuite) files() []string {
+	var files []string
+	_ = filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files
+}
+
+func (s *DiskSuite) TestSet() {
+	key := "test_key"
+	value := "test_value"
+	s.store.Set(&key, value)
+	returnedValue, ok := s.store.Get(key)
+	s.True(ok)
+	s.Equal(value, returnedValue)
+
+	files := s.files()
+	s.Len(files, 1)
+	rel, _ := filepath.Rel(s.dir, files[0])
+	parts := strings.Split(rel, string(filepath.Separator))
+	s.Len(parts, 3, "files are stored in sharded subdirectories")
+	s.Equal(parts[0]+parts[1], parts[2][:4])
+
+	s.store.Delete(key)
+	_, ok = s.store.Get(key)
+	s.False(ok)
+	s.Empty(s.files())
+}
+
+func (s *DiskSuite) TestTTL() {
+	s.store.Set(String("short"), "value", &ItemConfig{TTL: Duration(50 * time.Millisecond)})
+	s.store.Set(String("forever"), "value", &ItemConfig{TTL: Duration(0)})
+	_, ttl, ok := s.store.(TTLGetter).GetWithTTL("short")
+	s.True(ok)
+	s.Greater(ttl, time.Duration(0))
+	_, ttl, ok = s.store.(TTLGetter).GetWithTTL("forever")
+	s.True(ok)
+	s.Equal(time.Duration(0), ttl)
+
+	time.Sleep(60 * time.Millisecond)
+	_, ok = s.store.Get("short")
+	s.False(ok)
+	s.Equal([]string{"forever"}, s.store.Find("*"))
+	s.Len(s.files(), 1)
+}
+
+func (s *DiskSuite) TestCleanup() {
+	store, err := NewDiskAdapter(s.dir, CacheConfig{TTL: Duration(20 * time.Millisecond), CleanupInterval: Duration(10 * time.Millisecond)})
+	s.Require().NoError(err)
+	defer store.Close()
+	store.Set(String("key"), "value")
+	s.Len(s.files(), 1)
+	s.Eventually(func() bool { return len(s.files()) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func (s *DiskSuite) TestSizeBudget() {
+	value := strings.Repeat("a", 1000)
+	store, err := NewDiskAdapter(s.dir, CacheConfig{MaxBytes: 3500, MaxItemBytes: 2000})
+	s.Require().NoError(err)
+	defer store.Close()
+	disk := store.(*DiskAdapter)
+
+	store.Set(String("1"), value)
+	store.Set(String("2"), value)
+	store.Set(String("3"), value)
+	_, ok := store.Get("1")
+	s.True(ok)
+	store.Set(String("4"), value)
+
+	// The least recently used entry was evicted
+	s.ElementsMatch([]string{"1", "3", "4"}, store.Find("*"))
+	s.Len(s.files(), 3)
+	s.LessOrEqual(disk.Size(), int64(3500))
+
+	// Items larger than MaxItemBytes are rejected and replace the stored item
+	store.Set(String("1"), strings.Repeat("a", 3000))
+	_, ok = store.Get("1")
+	s.False(ok)
+	s.Len(s.files(), 2)
+}
+
+func (s *DiskSuite) TestRebuildIndex() {
+	value := strings.Repeat("a", 1000)
+	s.store.Set(String("/users/1"), value)
+	s.store.Set(String("/users/2"), value, &ItemConfig{TTL: Duration(time.Hour)})
+	s.store.Set(String("/expired"), value, &ItemConfig{TTL: Duration(time.Millisecond)})
+	s.NoError(s.store.Close())
+	// Leftovers of interrupted writes and broken files are deleted
+	s.NoError(os.WriteFile(filepath.Join(s.dir, diskTempPrefix+"1"), []byte("partial"), 0o644))
+	s.NoError(os.WriteFile(filepath.Join(s.dir, "broken"), []byte("broken"), 0o644))
+	time.Sleep(2 * time.Millisecond)
+
+	var err error
+	s.store, err = NewDiskAdapter(s.dir)
+	s.Require().NoError(err)
+	s.ElementsMatch([]string{"/users/1", "/users/2"}, s.store.Find("/users/*"))
+	s.Empty(s.store.Find("/expired"))
+	s.Len(s.files(), 2)
+	s.Equal(s.store.(*DiskAdapter).Size(), int64(2*(diskHeaderSize+len("/users/1")+len(`{"Data":""}`)+1000)))
+	d, ok := s.store.Get("/users/2")
+	s.True(ok)
+	s.Equal(value, d)
+	_, ttl, _ := s.store.(TTLGetter).GetWithTTL("/users/2")
+	s.Greater(ttl, 59*time.Minute)
+}
+
+func (s *DiskSuite) TestCorruptedEntries() {
+	var reported []string
+	store, err := NewDiskAdapter(s.dir, CacheConfig{
+		VerifyIntegrity:  true,
+		OnIntegrityError: func(key string, err error) { reported = append(reported, key) },
+	})
+	s.Require().NoError(err)
+	defer store.Close()
+	store.Set(String("key"), "value")
+	path := s.files()[0]
+	b, _ := os.ReadFile(path)
+	s.NoError(os.WriteFile(path, []byte(strings.Replace(string(b), "value", "evil!", 1)), 0o644))
+
+	_, ok := store.Get("key")
+	s.False(ok)
+	s.Equal([]string{"key"}, reported)
+	s.Empty(s.files())
+}
+
+func (s *DiskSuite) TestFlushAll() {
+	s.store.Set(String("1"), 1)
+	s.store.Set(String("2"), 2)
+	s.store.FlushAll()
+	s.Empty(s.store.Find("*"))
+	s.Empty(s.files())
+	s.Equal(int64(0), s.store.(*DiskAdapter).Size())
+}
+
+func (s *DiskSuite) TestWithMiddleware() {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware(s.store, nil))
+	export := strings.Repeat("id,name\n", 100000)
+	r.GET("/export", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, export)
+	})
+	for _, cache := range []string{HeaderXCacheMiss, HeaderXCacheHit} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/export", nil)
+		r.ServeHTTP(w, req)
+		s.Equal(cache, w.Header().Get(HeaderXCache))
+		s.Equal(export, w.Body.String())
+	}
+}
+
+func (s *DiskSuite) TestPing() {
+	s.NoError(s.store.(Pinger).Ping(context.Background()))
+	s.NoError(os.RemoveAll(s.dir))
+	s.Error(s.store.(Pinger).Ping(context.Background()))
+}
+
+func TestDiskSuite(t *testing.T) {
+	suite.Run(t, new(DiskSuite))
+}
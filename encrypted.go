@@ -0,0 +1,271 @@
+package ginche
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// encryptedVersion is the first byte of every ciphertext, it allows changing the format later
+const encryptedVersion byte = 1
+
+var (
+	errNoEncryptionKeys = errors.New("ginche: at least one encryption key is required")
+	errUnknownKeyID     = errors.New("ginche: unknown encryption key ID")
+	errBadCiphertext    = errors.New("ginche: malformed ciphertext")
+)
+
+// EncryptionKey is an AES key and the ID stored with values encrypted by it.
+// Key must be 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256,
+// ID must be unique and at most 255 bytes long.
+type EncryptionKey struct {
+	ID  string
+	Key []byte
+}
+
+// EncryptionOptions is the options for the encrypted adapter
+// Keys are the keys values are decrypted with, the first one also encrypts new values.
+// To rotate keys, put the new key first and keep the old ones until their values expire
+// KeySecret, if set, replaces keys by their HMAC-SHA256 before they reach the wrapped adapter,
+// so the stored keys do not reveal the cached URLs
+type EncryptionOptions struct {
+	Keys      []EncryptionKey
+	KeySecret []byte
+}
+
+// EncryptedAdapter encrypts values with AES-GCM before they are written to the wrapped adapter.
+// Every value carries the ID of the key it was encrypted with and is bound to its cache key,
+// so it can not be decrypted under another key. Values that can not be decrypted are misses.
+// If KeySecret is set, Find and Scan can not match the hashed keys and return no keys.
+type EncryptedAdapter struct {
+	adapter   CacheAdapter
+	keyID     string
+	aead      cipher.AEAD
+	keys      map[string]cipher.AEAD
+	keySecret []byte
+}
+
+// NewEncryptedAdapter creates an adapter encrypting values written to adapter.
+// The adapter takes ownership of the wrapped adapter and closes it on Close.
+func NewEncryptedAdapter(adapter CacheAdapter, options *EncryptionOptions) (*EncryptedAdapter, error) {
+	if options == nil || len(options.Keys) == 0 {
+		return nil, errNoEncryptionKeys
+	}
+	e := &EncryptedAdapter{
+		adapter:   adapter,
+		keyID:     options.Keys[0].ID,
+		keys:      make(map[string]cipher.AEAD, len(options.Keys)),
+		keySecret: options.KeySecret,
+	}
+	for _, key := range options.Keys {
+		if len(key.ID) > 255 {
+			return nil, fmt.Errorf("ginche: encryption key ID %q is too long", key.ID)
+		}
+		if _, ok := e.keys[key.ID]; ok {
+			return nil, fmt.Errorf("ginche: duplicate encryption key ID %q", key.ID)
+		}
+		block, err := aes.NewCipher(key.Key)
+		if err != nil {
+			return nil, fmt.Errorf("ginche: encryption key %q: %w", key.ID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		e.keys[key.ID] = aead
+	}
+	e.aead = e.keys[e.keyID]
+	return e, nil
+}
+
+// Set encrypts the value with the first key and writes it to the wrapped adapter.
+func (e *EncryptedAdapter) Set(key *string, value interface{}, config ...*ItemConfig) {
+	ciphertext, err := e.encrypt(*key, value)
+	if err != nil {
+		return
+	}
+	storageKey := e.storageKey(*key)
+	e.adapter.Set(&storageKey, ciphertext, config...)
+}
+
+func (e *EncryptedAdapter) Get(key string) (interface{}, bool) {
+	value, ok := e.adapter.Get(e.storageKey(key))
+	if !ok {
+		return nil, false
+	}
+	return e.decrypt(key, value)
+}
+
+// GetWithTTL works like Get and also returns the remaining TTL of the item,
+// if the wrapped adapter implements TTLGetter.
+func (e *EncryptedAdapter) GetWithTTL(key string) (interface{}, time.Duration, bool) {
+	getter, ok := e.adapter.(TTLGetter)
+	if !ok {
+		value, ok := e.Get(key)
+		return value, 0, ok
+	}
+	value, ttl, ok := getter.GetWithTTL(e.storageKey(key))
+	if !ok {
+		return nil, 0, false
+	}
+	data, ok := e.decrypt(key, value)
+	return data, ttl, ok
+}
+
+// GetMulti decrypts the values fetched from the wrapped adapter in a single batch.
+func (e *EncryptedAdapter) GetMulti(keys []string) map[string]interface{} {
+	storageKeys := make([]string, len(keys))
+	for i, key := range keys {
+		storageKeys[i] = e.storageKey(key)
+	}
+	found := GetMulti(e.adapter, storageKeys)
+	values := make(map[string]interface{}, len(found))
+	for i, key := range keys {
+		value, ok := found[storageKeys[i]]
+		if !ok {
+			continue
+		}
+		if data, ok := e.decrypt(key, value); ok {
+			values[key] = data
+		}
+	}
+	return values
+}
+
+// SetMulti encrypts the values and writes them to the wrapped adapter in a single batch.
+func (e *EncryptedAdapter) SetMulti(items map[string]interface{}, config ...*ItemConfig) {
+	encrypted := make(map[string]interface{}, len(items))
+	for key, value := range items {
+		ciphertext, err := e.encrypt(key, value)
+		if err != nil {
+			continue
+		}
+		encrypted[e.storageKey(key)] = ciphertext
+	}
+	SetMulti(e.adapter, encrypted, config...)
+}
+
+func (e *EncryptedAdapter) DeleteMulti(keys []string) {
+	storageKeys := make([]string, len(keys))
+	for i, key := range keys {
+		storageKeys[i] = e.storageKey(key)
+	}
+	DeleteMulti(e.adapter, storageKeys)
+}
+
+func (e *EncryptedAdapter) Delete(key string) {
+	e.adapter.Delete(e.storageKey(key))
+}
+
+// Find returns keys matching the pattern, or no keys if KeySecret is set.
+func (e *EncryptedAdapter) Find(pattern string) []string {
+	if e.keySecret != nil {
+		return []string{}
+	}
+	return e.adapter.Find(pattern)
+}
+
+// Scan walks the keys of the wrapped adapter if it implements Scanner.
+// It returns no keys if KeySecret is set.
+func (e *EncryptedAdapter) Scan(ctx context.Context, pattern string, cursor uint64, count int64) ([]string, uint64, error) {
+	if e.keySecret != nil {
+		return []string{}, 0, nil
+	}
+	scanner, ok := e.adapter.(Scanner)
+	if !ok {
+		return nil, 0, ErrScanNotSupported
+	}
+	return scanner.Scan(ctx, pattern, cursor, count)
+}
+
+func (e *EncryptedAdapter) FlushAll() {
+	e.adapter.FlushAll()
+}
+
+// Ping checks the wrapped adapter if it implements Pinger.
+func (e *EncryptedAdapter) Ping(ctx context.Context) error {
+	if p, ok := e.adapter.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// Close closes the wrapped adapter.
+func (e *EncryptedAdapter) Close() error {
+	return e.adapter.Close()
+}
+
+// storageKey returns the key written to the wrapped adapter.
+func (e *EncryptedAdapter) storageKey(key string) string {
+	if e.keySecret == nil {
+		return key
+	}
+	mac := hmac.New(sha256.New, e.keySecret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// encrypt serializes the value and returns it encrypted as
+// base64(version | len(key ID) | key ID | nonce | sealed value),
+// with the cache key as additional data.
+func (e *EncryptedAdapter) encrypt(key string, value interface{}) (string, error) {
+	plaintext, err := json.Marshal(item{Data: value})
+	if err != nil {
+		return "", err
+	}
+	header := make([]byte, 0, 2+len(e.keyID)+e.aead.NonceSize())
+	header = append(header, encryptedVersion, byte(len(e.keyID)))
+	header = append(header, e.keyID...)
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	header = append(header, nonce...)
+	return base64.StdEncoding.EncodeToString(e.aead.Seal(header, nonce, plaintext, []byte(key))), nil
+}
+
+// decrypt returns the value encrypted by encrypt, with any of the configured keys.
+func (e *EncryptedAdapter) decrypt(key string, value interface{}) (interface{}, bool) {
+	encoded, ok := value.(string)
+	if !ok {
+		return nil, false
+	}
+	plaintext, err := e.open(key, encoded)
+	if err != nil {
+		return nil, false
+	}
+	var data item
+	if err := json.Unmarshal(plaintext, &data); err != nil {
+		return nil, false
+	}
+	return data.Data, true
+}
+
+func (e *EncryptedAdapter) open(key, encoded string) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < 2 || ciphertext[0] != encryptedVersion || len(ciphertext) < 2+int(ciphertext[1]) {
+		return nil, errBadCiphertext
+	}
+	idEnd := 2 + int(ciphertext[1])
+	aead, ok := e.keys[string(ciphertext[2:idEnd])]
+	if !ok {
+		return nil, errUnknownKeyID
+	}
+	if len(ciphertext) < idEnd+aead.NonceSize() {
+		return nil, errBadCiphertext
+	}
+	nonce := ciphertext[idEnd : idEnd+aead.NonceSize()]
+	return aead.Open(nil, nonce, ciphertext[idEnd+aead.NonceSize():], []byte(key))
+}
@@ -0,0 +1,147 @@
+package ginche
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type EncryptedSuite struct {
+	suite.Suite
+	inner  *InMemoryCache
+	oldKey EncryptionKey
+	newKey EncryptionKey
+}
+
+func (s *EncryptedSuite) SetupTest() {
+	s.inner = NewInMemoryCache(CacheConfig{TTL: Duration(time.Hour)}).(*InMemoryCache)
+	s.oldKey = EncryptionKey{ID: "2023", Key: bytes.Repeat([]byte{1}, 32)}
+	s.newKey = EncryptionKey{ID: "2024", Key: bytes.Repeat([]byte{2}, 16)}
+}
+
+func (s *EncryptedSuite) TestEncryptsValues() {
+	store, err := NewEncryptedAdapter(s.inner, &EncryptionOptions{Keys: []EncryptionKey{s.newKey}})
+	s.Require().NoError(err)
+	defer store.Close()
+	store.Set(String("/users/1"), "secret value")
+
+	stored, ok := s.inner.Get("/users/1")
+	s.True(ok)
+	s.IsType("", stored)
+	s.NotContains(stored, "secret")
+	d, ok := store.Get("/users/1")
+	s.True(ok)
+	s.Equal("secret value", d)
+	s.Equal([]string{"/users/1"}, store.Find("/users/*"))
+
+	// Values are bound to their key
+	s.inner.Set(String("/users/2"), stored)
+	_, ok = store.Get("/users/2")
+	s.False(ok)
+	// Tampered or plaintext values are misses
+	ciphertext, _ := base64.StdEncoding.DecodeString(stored.(string))
+	ciphertext[len(ciphertext)-1] ^= 1
+	s.inner.Set(String("/users/1"), base64.StdEncoding.EncodeToString(ciphertext))
+	_, ok = store.Get("/users/1")
+	s.False(ok)
+	s.inner.Set(String("/users/1"), "plaintext")
+	_, ok = store.Get("/users/1")
+	s.False(ok)
+
+	store.Delete("/users/1")
+	_, ok = s.inner.Get("/users/1")
+	s.False(ok)
+}
+
+func (s *EncryptedSuite) TestKeyRotation() {
+	old, _ := NewEncryptedAdapter(s.inner, &EncryptionOptions{Keys: []EncryptionKey{s.oldKey}})
+	old.Set(String("old"), "old value")
+
+	rotated, err := NewEncryptedAdapter(s.inner, &EncryptionOptions{Keys: []EncryptionKey{s.newKey, s.oldKey}})
+	s.Require().NoError(err)
+	defer rotated.Close()
+	rotated.Set(String("new"), "new value")
+	s.Equal(map[string]interface{}{"old": "old value", "new": "new value"}, rotated.GetMulti([]string{"old", "new", "missing"}))
+
+	// Values of keys that were dropped can no longer be read
+	_, ok := old.Get("new")
+	s.False(ok)
+	d, ok := old.Get("old")
+	s.True(ok)
+	s.Equal("old value", d)
+}
+
+func (s *EncryptedSuite) TestKeySecret() {
+	store, _ := NewEncryptedAdapter(s.inner, &EncryptionOptions{Keys: []EncryptionKey{s.newKey}, KeySecret: []byte("secret")})
+	defer store.Close()
+	store.SetMulti(map[string]interface{}{"/users/1": 1, "/users/2": 2})
+
+	for _, key := range s.inner.Find("*") {
+		s.NotContains(key, "users")
+		s.Len(key, 64)
+	}
+	s.Len(s.inner.Find("*"), 2)
+	s.Empty(store.Find("*"))
+	d, ok := store.Get("/users/1")
+	s.True(ok)
+	s.Equal(float64(1), d)
+	store.DeleteMulti([]string{"/users/1", "/users/2"})
+	s.Empty(s.inner.Find("*"))
+}
+
+func (s *EncryptedSuite) TestInvalidOptions() {
+	tests := []struct {
+		name    string
+		options *EncryptionOptions
+	}{
+		{"nil", nil},
+		{"no keys", &EncryptionOptions{}},
+		{"bad key size", &EncryptionOptions{Keys: []EncryptionKey{{ID: "1", Key: []byte("short")}}}},
+		{"duplicate ID", &EncryptionOptions{Keys: []EncryptionKey{s.oldKey, {ID: s.oldKey.ID, Key: s.newKey.Key}}}},
+		{"long ID", &EncryptionOptions{Keys: []EncryptionKey{{ID: strings.Repeat("a", 256), Key: s.newKey.Key}}}},
+	}
+	for _, tt := range tests {
+		_, err := NewEncryptedAdapter(s.inner, tt.options)
+		s.Error(err, tt.name)
+	}
+}
+
+func (s *EncryptedSuite) TestWithRedisAndMiddleware() {
+	mRedis := miniredis.RunT(s.T())
+	redisStore, err := NewRedisAdapter(&redis.Options{Addr: mRedis.Addr()})
+	s.Require().NoError(err)
+	store, _ := NewEncryptedAdapter(redisStore, &EncryptionOptions{Keys: []EncryptionKey{s.newKey}, KeySecret: []byte("secret")})
+	defer store.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware(store, nil))
+	r.GET("/account", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, "card number")
+	})
+	for _, cache := range []string{HeaderXCacheMiss, HeaderXCacheHit} {
+		redisStore.(*RedisAdapter).inMemoryCache.FlushAll()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/account", nil)
+		r.ServeHTTP(w, req)
+		s.Equal(cache, w.Header().Get(HeaderXCache))
+		s.Equal("card number", w.Body.String())
+	}
+	for _, key := range mRedis.Keys() {
+		s.NotContains(key, "account")
+		value, _ := mRedis.Get(key)
+		s.NotContains(value, "card number")
+	}
+}
+
+func TestEncryptedSuite(t *testing.T) {
+	suite.Run(t, new(EncryptedSuite))
+}
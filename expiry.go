@@ -0,0 +1,60 @@
+package ginche
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiryResolution is the shortest delay between two cleanup runs.
+// Items expiring within the same resolution window are reclaimed together.
+const expiryResolution = 10 * time.Millisecond
+
+// expiryHeap is a min-heap of items ordered by expiration time.
+// Each item remembers its index, so it can be fixed or removed in O(log n).
+type expiryHeap []*Item
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	item := x.(*Item)
+	item.heapIndex = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.heapIndex = -1
+	*h = old[:n-1]
+	return item
+}
+
+func (h *expiryHeap) add(item *Item) {
+	heap.Push(h, item)
+}
+
+func (h *expiryHeap) update(item *Item) {
+	heap.Fix(h, item.heapIndex)
+}
+
+func (h *expiryHeap) remove(item *Item) {
+	heap.Remove(h, item.heapIndex)
+}
+
+// peek returns the item that expires first or nil if the heap is empty.
+func (h expiryHeap) peek() *Item {
+	if len(h) == 0 {
+		return nil
+	}
+	return h[0]
+}
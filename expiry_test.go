@@ -0,0 +1,51 @@
+package ginche
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiryHeap(t *testing.T) {
+	var h expiryHeap
+	now := time.Now()
+	items := []*Item{
+		{key: "c", expiresAt: now.Add(3 * time.Second)},
+		{key: "a", expiresAt: now.Add(1 * time.Second)},
+		{key: "b", expiresAt: now.Add(2 * time.Second)},
+	}
+	for _, item := range items {
+		h.add(item)
+	}
+	if got := h.peek().key; got != "a" {
+		t.Errorf("peek() = %v, want a", got)
+	}
+
+	items[0].expiresAt = now
+	h.update(items[0])
+	if got := h.peek().key; got != "c" {
+		t.Errorf("peek() after update = %v, want c", got)
+	}
+
+	h.remove(items[0])
+	if got := h.peek().key; got != "a" {
+		t.Errorf("peek() after remove = %v, want a", got)
+	}
+	if items[0].heapIndex != -1 {
+		t.Errorf("heapIndex = %v, want -1", items[0].heapIndex)
+	}
+}
+
+func TestCleanupOnExpiry(t *testing.T) {
+	// The cleanup interval is long, expired items must be reclaimed anyway
+	cache := NewInMemoryCache(CacheConfig{CleanupInterval: Duration(time.Hour)}).(*InMemoryCache)
+	cache.Set(String("short"), 1, &ItemConfig{TTL: Duration(50 * time.Millisecond)})
+	cache.Set(String("long"), 2)
+
+	time.Sleep(300 * time.Millisecond)
+	if got := cache.Len(); got != 1 {
+		t.Errorf("Len() = %v, want 1", got)
+	}
+	if _, ok := cache.Get("long"); !ok {
+		t.Errorf("Get(long) = false, want true")
+	}
+}
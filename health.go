@@ -0,0 +1,127 @@
+package ginche
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// HealthUp is the status of an adapter that answered its ping
+	HealthUp = "up"
+	// HealthDown is the status of an adapter whose ping failed or timed out
+	HealthDown = "down"
+)
+
+// Pinger is implemented by adapters that can check the connection to their storage.
+// All adapters of this package implement it.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Health is the result of a health check of an adapter.
+// Invalidation is only set for adapters keeping local caches coherent through an InvalidationBus.
+type Health struct {
+	Status       string     `json:"status"`
+	Latency      string     `json:"latency"`
+	LatencyMs    float64    `json:"latency_ms"`
+	Error        string     `json:"error,omitempty"`
+	Invalidation *BusHealth `json:"invalidation,omitempty"`
+}
+
+// BusHealth is the state of an invalidation bus as reported by health checks.
+type BusHealth struct {
+	Connected  bool      `json:"connected"`
+	Since      time.Time `json:"since"`
+	Reconnects int       `json:"reconnects"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// CheckHealth pings the adapter and measures the latency.
+// Adapters that do not implement Pinger are reported as up.
+func CheckHealth(ctx context.Context, adapter CacheAdapter) Health {
+	var err error
+	start := time.Now()
+	if p, ok := adapter.(Pinger); ok {
+		err = p.Ping(ctx)
+	}
+	latency := time.Since(start)
+	health := Health{
+		Status:    HealthUp,
+		Latency:   latency.String(),
+		LatencyMs: float64(latency) / float64(time.Millisecond),
+	}
+	if err != nil {
+		health.Status = HealthDown
+		health.Error = err.Error()
+	}
+	if r, ok := adapter.(interface{ InvalidationStatus() BusStatus }); ok {
+		status := r.InvalidationStatus()
+		health.Invalidation = &BusHealth{
+			Connected:  status.Connected,
+			Since:      status.Since,
+			Reconnects: status.Reconnects,
+		}
+		if !status.Connected && status.LastError != nil {
+			health.Invalidation.Error = status.LastError.Error()
+		}
+	}
+	return health
+}
+
+// HealthHandler returns a gin handler checking the health of the adapters, e.g. for readiness probes.
+// Adapters are pinged concurrently, each within the timeout, defaulting to 1 second.
+// It responds with 200 if all adapters are up and 503 otherwise, with the status of every adapter:
+//
+//	{"status": "up", "adapters": {"redis": {"status": "up", "latency": "512µs", "latency_ms": 0.512}}}
+func HealthHandler(adapters map[string]CacheAdapter, timeout time.Duration) gin.HandlerFunc {
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	names := make([]string, 0, len(adapters))
+	for name := range adapters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return func(ctx *gin.Context) {
+		checkCtx, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+		defer cancel()
+		results := make([]Health, len(names))
+		done := make(chan struct{}, len(names))
+		for i, name := range names {
+			go func(i int, adapter CacheAdapter) {
+				results[i] = CheckHealth(checkCtx, adapter)
+				done <- struct{}{}
+			}(i, adapters[name])
+		}
+		for range names {
+			<-done
+		}
+
+		status, code := HealthUp, http.StatusOK
+		body := make(map[string]Health, len(names))
+		for i, name := range names {
+			body[name] = results[i]
+			if results[i].Status != HealthUp {
+				status, code = HealthDown, http.StatusServiceUnavailable
+			}
+		}
+		ctx.JSON(code, gin.H{"status": status, "adapters": body})
+	}
+}
+
+// pingWithTimeout pings the adapter at construction if a PingTimeout is configured.
+func pingWithTimeout(p Pinger, timeout time.Duration) error {
+	if timeout <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := p.Ping(ctx); err != nil {
+		return fmt.Errorf("ginche: ping failed: %w", err)
+	}
+	return nil
+}
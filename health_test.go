@@ -0,0 +1,119 @@
+package ginche
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// deadAddr returns an address nothing listens on.
+func deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestPingAtConstruction(t *testing.T) {
+	server := miniredis.RunT(t)
+	memcached, err := newFakeMemcached()
+	assert.NoError(t, err)
+	defer memcached.Close()
+	conf := CacheConfig{PingTimeout: 200 * time.Millisecond}
+
+	store, err := NewRedisAdapter(&redis.Options{Addr: server.Addr()}, conf)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+	_, err = NewRedisAdapter(&redis.Options{Addr: deadAddr(t), MaxRetries: -1}, conf)
+	assert.Error(t, err)
+
+	client := redis.NewClient(&redis.Options{Addr: deadAddr(t), MaxRetries: -1})
+	_, err = NewRedisAdapterWithClient(client, conf)
+	assert.Error(t, err)
+	// The client belongs to the caller and is not closed
+	assert.NotErrorIs(t, client.Ping(context.Background()).Err(), redis.ErrClosed)
+	client.Close()
+
+	_, err = NewShardedRedisAdapter(&ShardedRedisOptions{
+		Nodes:   map[string]string{"a": server.Addr(), "b": deadAddr(t)},
+		Options: &redis.Options{MaxRetries: -1},
+	}, conf)
+	assert.Error(t, err)
+
+	store, err = NewMemcachedAdapter(&MemcachedOptions{Servers: []string{memcached.Addr()}}, conf)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+	_, err = NewMemcachedAdapter(&MemcachedOptions{Servers: []string{memcached.Addr(), deadAddr(t)}}, conf)
+	assert.Error(t, err)
+
+	// Without a timeout constructors do not connect
+	store, err = NewRedisAdapter(&redis.Options{Addr: deadAddr(t), MaxRetries: -1})
+	assert.NoError(t, err)
+	assert.Error(t, store.(Pinger).Ping(context.Background()))
+	store.Close()
+}
+
+func TestTieredPing(t *testing.T) {
+	server := miniredis.RunT(t)
+	l2, _ := NewRedisAdapter(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	store := NewTieredAdapter(NewInMemoryCache(), l2, nil)
+	defer store.Close()
+	assert.NoError(t, store.Ping(context.Background()))
+
+	server.Close()
+	err := store.Ping(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "l2: ")
+}
+
+func TestHealthHandler(t *testing.T) {
+	server := miniredis.RunT(t)
+	memory := NewInMemoryCache()
+	defer memory.Close()
+	store, _ := NewRedisAdapter(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	defer store.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/health", HealthHandler(map[string]CacheAdapter{"memory": memory, "redis": store}, 200*time.Millisecond))
+	check := func() (int, map[string]Health, string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/health", nil)
+		r.ServeHTTP(w, req)
+		var body struct {
+			Status   string
+			Adapters map[string]Health
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body.Adapters, body.Status
+	}
+
+	assert.Eventually(t, func() bool {
+		return store.(*RedisAdapter).InvalidationStatus().Connected
+	}, time.Second, 10*time.Millisecond)
+	code, adapters, status := check()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, HealthUp, status)
+	assert.Equal(t, HealthUp, adapters["memory"].Status)
+	assert.Nil(t, adapters["memory"].Invalidation)
+	assert.Equal(t, HealthUp, adapters["redis"].Status)
+	assert.NotEmpty(t, adapters["redis"].Latency)
+	assert.True(t, adapters["redis"].Invalidation.Connected)
+
+	server.Close()
+	code, adapters, status = check()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, HealthDown, status)
+	assert.Equal(t, HealthUp, adapters["memory"].Status)
+	assert.Equal(t, HealthDown, adapters["redis"].Status)
+	assert.NotEmpty(t, adapters["redis"].Error)
+}
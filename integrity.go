@@ -0,0 +1,97 @@
+package ginche
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+)
+
+// Header bytes of values carrying an integrity check, followed by the check and the value.
+const (
+	checkedCRC32 byte = 0x03
+	checkedHMAC  byte = 0x04
+)
+
+// ErrIntegrity is reported for stored entries that fail their integrity check.
+var ErrIntegrity = errors.New("ginche: integrity check failed")
+
+var crc32Table = crc32.MakeTable(crc32.Castagnoli)
+
+// seal encodes the value and, if VerifyIntegrity is enabled, prepends its checksum,
+// or its HMAC if IntegritySecret is set. The check covers the key the value is stored under.
+func (c *valueCodec) seal(key string, value []byte) []byte {
+	value, _ = c.encode(value)
+	if !c.verify {
+		return value
+	}
+	header := checkedCRC32
+	if c.secret != nil {
+		header = checkedHMAC
+	}
+	sum := c.sum(key, value)
+	out := make([]byte, 0, 1+len(sum)+len(value))
+	out = append(out, header)
+	out = append(out, sum...)
+	return append(out, value...)
+}
+
+// open verifies and decodes a value written by seal.
+// Values without a check are accepted unless IntegritySecret is set,
+// so values written before verification was enabled can still be read.
+// Values with an HMAC can only be read with the secret.
+func (c *valueCodec) open(key string, value []byte) ([]byte, error) {
+	if len(value) == 0 || (value[0] != checkedCRC32 && value[0] != checkedHMAC) {
+		if c.secret != nil {
+			return nil, ErrIntegrity
+		}
+		return c.decode(value)
+	}
+	if (value[0] == checkedHMAC) != (c.secret != nil) {
+		return nil, ErrIntegrity
+	}
+	size := crc32.Size
+	if value[0] == checkedHMAC {
+		size = sha256.Size
+	}
+	if len(value) < 1+size || !hmac.Equal(value[1:1+size], c.sum(key, value[1+size:])) {
+		return nil, ErrIntegrity
+	}
+	return c.decode(value[1+size:])
+}
+
+// sum returns the CRC-32C, or the HMAC-SHA256 if IntegritySecret is set, of the key and the parts.
+// Parts are length-prefixed, so their boundaries are covered too.
+func (c *valueCodec) sum(key string, parts ...[]byte) []byte {
+	var h hash.Hash
+	if c.secret != nil {
+		h = hmac.New(sha256.New, c.secret)
+	} else {
+		h = crc32.New(crc32Table)
+	}
+	var size [8]byte
+	for _, part := range append([][]byte{[]byte(key)}, parts...) {
+		binary.BigEndian.PutUint64(size[:], uint64(len(part)))
+		h.Write(size[:])
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// corrupted reports an entry that failed verification or decoding, if VerifyIntegrity is enabled.
+// Decoding errors are wrapped in ErrIntegrity. It returns true if the entry should be deleted.
+func (c *valueCodec) corrupted(key string, err error) bool {
+	if !c.verify {
+		return false
+	}
+	if !errors.Is(err, ErrIntegrity) {
+		err = fmt.Errorf("%w: %v", ErrIntegrity, err)
+	}
+	if c.onError != nil {
+		c.onError(key, err)
+	}
+	return true
+}
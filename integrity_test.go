@@ -0,0 +1,61 @@
+package ginche
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestValueCodecIntegrity(t *testing.T) {
+	value := []byte(`{"Data":"value"}`)
+	checksums := newValueCodec(CacheConfig{VerifyIntegrity: true})
+	signed := newValueCodec(CacheConfig{IntegritySecret: []byte("secret")})
+	otherSecret := newValueCodec(CacheConfig{IntegritySecret: []byte("other")})
+	plain := newValueCodec(CacheConfig{})
+	compressed := newValueCodec(CacheConfig{IntegritySecret: []byte("secret"), Compression: CompressionGzip, CompressionThreshold: 1})
+	large := []byte(`{"Data":"` + strings.Repeat("a", 1000) + `"}`)
+
+	tamper := func(b []byte) []byte {
+		out := append([]byte(nil), b...)
+		out[len(out)-2] ^= 1
+		return out
+	}
+	tests := []struct {
+		name   string
+		writer *valueCodec
+		reader *valueCodec
+		value  []byte
+		key    string
+		modify func([]byte) []byte
+		err    error
+	}{
+		{"checksum", checksums, checksums, value, "key", nil, nil},
+		{"checksum read without verification", checksums, plain, value, "key", nil, nil},
+		{"unchecked value", plain, checksums, value, "key", nil, nil},
+		{"hmac", signed, signed, value, "key", nil, nil},
+		{"hmac with compression", compressed, signed, large, "key", nil, nil},
+		{"tampered checksum", checksums, checksums, value, "key", tamper, ErrIntegrity},
+		{"tampered hmac", signed, signed, value, "key", tamper, ErrIntegrity},
+		{"moved to another key", signed, signed, value, "other", nil, ErrIntegrity},
+		{"other secret", signed, otherSecret, value, "key", nil, ErrIntegrity},
+		{"unsigned value", plain, signed, value, "key", nil, ErrIntegrity},
+		{"checksum instead of hmac", checksums, signed, value, "key", nil, ErrIntegrity},
+		{"hmac without secret", signed, checksums, value, "key", nil, ErrIntegrity},
+		{"truncated", signed, signed, value, "key", func(b []byte) []byte { return b[:10] }, ErrIntegrity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed := tt.writer.seal("key", tt.value)
+			if tt.modify != nil {
+				sealed = tt.modify(sealed)
+			}
+			opened, err := tt.reader.open(tt.key, sealed)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.value, opened)
+		})
+	}
+}
@@ -0,0 +1,325 @@
+package ginche
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultInvalidationChannel is the Redis channel used by RedisInvalidationBus if none is given.
+const DefaultInvalidationChannel = "ginche:invalidations"
+
+// InvalidationKind is the kind of an invalidation event.
+type InvalidationKind string
+
+const (
+	// InvalidateKey tells instances to drop a single key from their local caches.
+	InvalidateKey InvalidationKind = "key"
+	// InvalidateTag tells instances to drop all keys with a tag.
+	// Local caches without a tag index drop everything.
+	InvalidateTag InvalidationKind = "tag"
+	// InvalidateFlush tells instances to drop all keys of the namespace.
+	InvalidateFlush InvalidationKind = "flush"
+	// InvalidateReset is delivered locally when events may have been missed,
+	// e.g. after the bus reconnected. Handlers drop all local copies regardless of namespace.
+	InvalidateReset InvalidationKind = "reset"
+)
+
+// InvalidationEvent is a message sent between instances sharing a cache.
+// Key holds the key or the tag, depending on Kind.
+// Keys holds further keys of batch operations, so they are sent as a single event.
+// Source is the ID of the publishing instance, so it can skip its own events.
+type InvalidationEvent struct {
+	Kind      InvalidationKind `json:"kind"`
+	Namespace string           `json:"ns,omitempty"`
+	Key       string           `json:"key,omitempty"`
+	Keys      []string         `json:"keys,omitempty"`
+	Source    string           `json:"src,omitempty"`
+}
+
+// InvalidationBus delivers invalidation events to all instances sharing a cache.
+// Handlers receive every event, including the ones published by the same instance,
+// use InvalidationEvent.Source to skip them.
+type InvalidationBus interface {
+	Publish(ctx context.Context, event InvalidationEvent) error
+	Subscribe(handler func(InvalidationEvent)) (unsubscribe func())
+	Close() error
+}
+
+// invalidationHandlers is a set of handlers shared by bus implementations.
+type invalidationHandlers struct {
+	mu       sync.RWMutex
+	handlers map[int]func(InvalidationEvent)
+	next     int
+}
+
+func (h *invalidationHandlers) add(handler func(InvalidationEvent)) func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.handlers == nil {
+		h.handlers = make(map[int]func(InvalidationEvent))
+	}
+	id := h.next
+	h.next++
+	h.handlers[id] = handler
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.handlers, id)
+	}
+}
+
+func (h *invalidationHandlers) dispatch(event InvalidationEvent) {
+	h.mu.RLock()
+	handlers := make([]func(InvalidationEvent), 0, len(h.handlers))
+	for _, handler := range h.handlers {
+		handlers = append(handlers, handler)
+	}
+	h.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// InProcessBus delivers events synchronously to handlers in the same process.
+// It lets tests run several adapters as if they were separate instances.
+type InProcessBus struct {
+	handlers invalidationHandlers
+}
+
+// NewInProcessBus creates a bus delivering events within the process.
+func NewInProcessBus() *InProcessBus {
+	return &InProcessBus{}
+}
+
+func (b *InProcessBus) Publish(ctx context.Context, event InvalidationEvent) error {
+	b.handlers.dispatch(event)
+	return nil
+}
+
+func (b *InProcessBus) Subscribe(handler func(InvalidationEvent)) func() {
+	return b.handlers.add(handler)
+}
+
+func (b *InProcessBus) Close() error {
+	return nil
+}
+
+// RedisBusOptions is used to configure a RedisInvalidationBus.
+// If Channel is empty, DefaultInvalidationChannel is used.
+// After a connection error the bus waits MinBackoff (100ms by default) before reconnecting,
+// doubling the wait after each failed attempt up to MaxBackoff (10s by default).
+// HealthCheckInterval (5s by default) is how long the bus may stay silent before it pings Redis
+// to detect dead connections.
+type RedisBusOptions struct {
+	Channel             string
+	MinBackoff          time.Duration
+	MaxBackoff          time.Duration
+	HealthCheckInterval time.Duration
+}
+
+// BusStatus is the state of the connection of an invalidation bus.
+// Since is when Connected last changed, Reconnects counts re-established subscriptions
+// and LastError is the last error the bus ran into.
+type BusStatus struct {
+	Connected  bool
+	Since      time.Time
+	Reconnects int
+	LastError  error
+}
+
+// RedisInvalidationBus delivers events through a single Redis pub/sub channel,
+// with events encoded as JSON payloads.
+// It reconnects with exponential backoff when the connection is lost, and delivers
+// an InvalidateReset event once the subscription is re-established,
+// since events published in the meantime are lost.
+type RedisInvalidationBus struct {
+	conn      redis.UniversalClient
+	options   RedisBusOptions
+	pubsub    *redis.PubSub
+	handlers  invalidationHandlers
+	mu        sync.RWMutex
+	status    BusStatus
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewRedisInvalidationBus subscribes to the channel and starts delivering its events.
+// If options is nil, the defaults of RedisBusOptions are used.
+// Closing the bus does not close the client.
+func NewRedisInvalidationBus(client redis.UniversalClient, options *RedisBusOptions) *RedisInvalidationBus {
+	var opts RedisBusOptions
+	if options != nil {
+		opts = *options
+	}
+	if opts.Channel == "" {
+		opts.Channel = DefaultInvalidationChannel
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = 10 * time.Second
+		if opts.MaxBackoff < opts.MinBackoff {
+			opts.MaxBackoff = opts.MinBackoff
+		}
+	}
+	if opts.HealthCheckInterval <= 0 {
+		opts.HealthCheckInterval = 5 * time.Second
+	}
+	b := &RedisInvalidationBus{
+		conn:    client,
+		options: opts,
+		pubsub:  client.Subscribe(context.Background(), opts.Channel),
+		status:  BusStatus{Since: time.Now()},
+		done:    make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.receive()
+	}()
+	return b
+}
+
+// Publish sends the event to the channel.
+// On a *redis.Ring the event is sent to every shard, since the shard holding
+// the subscription changes with the set of shards.
+func (b *RedisInvalidationBus) Publish(ctx context.Context, event InvalidationEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if ring, ok := b.conn.(*redis.Ring); ok {
+		return ring.ForEachShard(ctx, func(ctx context.Context, shard *redis.Client) error {
+			return shard.Publish(ctx, b.options.Channel, payload).Err()
+		})
+	}
+	return b.conn.Publish(ctx, b.options.Channel, payload).Err()
+}
+
+func (b *RedisInvalidationBus) Subscribe(handler func(InvalidationEvent)) func() {
+	return b.handlers.add(handler)
+}
+
+// Status returns the state of the subscription.
+func (b *RedisInvalidationBus) Status() BusStatus {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.status
+}
+
+// receive delivers events from the channel to the handlers.
+// Failed connections are retried by the next Receive after a backoff,
+// go-redis resubscribes to the channel on reconnect.
+// It returns once the bus is closed.
+func (b *RedisInvalidationBus) receive() {
+	ctx := context.Background()
+	backoff := b.options.MinBackoff
+	for {
+		msg, err := b.pubsub.ReceiveTimeout(ctx, b.options.HealthCheckInterval)
+		if err != nil {
+			if b.closed() || errors.Is(err, redis.ErrClosed) {
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				// The channel was silent, make sure the connection is still alive
+				// and let the pong arrive with the next Receive.
+				if err = b.pubsub.Ping(ctx); err == nil {
+					continue
+				}
+			}
+			b.disconnected(err)
+			select {
+			case <-b.done:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > b.options.MaxBackoff {
+				backoff = b.options.MaxBackoff
+			}
+			continue
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			backoff = b.options.MinBackoff
+			if b.connected() {
+				b.handlers.dispatch(InvalidationEvent{Kind: InvalidateReset})
+			}
+		case *redis.Message:
+			var event InvalidationEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				log.Printf("Error decoding invalidation event: %v", err)
+				continue
+			}
+			b.handlers.dispatch(event)
+		}
+	}
+}
+
+// connected marks the subscription as established
+// and reports whether it was re-established after a failure.
+func (b *RedisInvalidationBus) connected() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.status.Connected {
+		return false
+	}
+	reconnected := b.status.LastError != nil
+	b.status.Connected = true
+	b.status.Since = time.Now()
+	if reconnected {
+		b.status.Reconnects++
+	}
+	return reconnected
+}
+
+// disconnected records the error, logging it only when the connection was lost,
+// so a long outage does not flood the log.
+func (b *RedisInvalidationBus) disconnected(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.status.LastError = err
+	if b.status.Connected {
+		log.Printf("Invalidation bus lost its connection, reconnecting: %v", err)
+		b.status.Connected = false
+		b.status.Since = time.Now()
+	}
+}
+
+func (b *RedisInvalidationBus) closed() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close unsubscribes from the channel and waits for the delivery to stop.
+func (b *RedisInvalidationBus) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		close(b.done)
+		err = b.pubsub.Close()
+		b.wg.Wait()
+	})
+	return err
+}
+
+// randomID returns a random hex string identifying an instance or a write.
+func randomID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
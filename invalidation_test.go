@@ -0,0 +1,134 @@
+package ginche
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInProcessBus(t *testing.T) {
+	bus := NewInProcessBus()
+	var first, second []InvalidationEvent
+	unsubscribe := bus.Subscribe(func(event InvalidationEvent) {
+		first = append(first, event)
+	})
+	bus.Subscribe(func(event InvalidationEvent) {
+		second = append(second, event)
+	})
+
+	event := InvalidationEvent{Kind: InvalidateKey, Namespace: "ns", Key: "key", Source: "a"}
+	assert.NoError(t, bus.Publish(context.Background(), event))
+	assert.Equal(t, []InvalidationEvent{event}, first)
+	assert.Equal(t, []InvalidationEvent{event}, second)
+
+	unsubscribe()
+	assert.NoError(t, bus.Publish(context.Background(), InvalidationEvent{Kind: InvalidateFlush}))
+	assert.Len(t, first, 1)
+	assert.Len(t, second, 2)
+	assert.NoError(t, bus.Close())
+}
+
+func TestRedisInvalidationBus(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	publisher := NewRedisInvalidationBus(client, nil)
+	subscriber := NewRedisInvalidationBus(client, nil)
+	other := NewRedisInvalidationBus(client, &RedisBusOptions{Channel: "other"})
+
+	var mu sync.Mutex
+	var received []InvalidationEvent
+	subscriber.Subscribe(func(event InvalidationEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, event)
+	})
+	var otherReceived bool
+	other.Subscribe(func(event InvalidationEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		otherReceived = true
+	})
+
+	event := InvalidationEvent{Kind: InvalidateTag, Namespace: "ns", Key: "users", Source: "a"}
+	assert.NoError(t, publisher.Publish(context.Background(), event))
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 1
+	}, time.Second, 10*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, event, received[0])
+	assert.False(t, otherReceived)
+	mu.Unlock()
+
+	assert.NoError(t, publisher.Close())
+	assert.NoError(t, subscriber.Close())
+	assert.NoError(t, subscriber.Close())
+	assert.NoError(t, other.Close())
+	// The client is not closed with the bus
+	assert.NoError(t, client.Ping(context.Background()).Err())
+}
+
+func TestRedisInvalidationBusReconnect(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	defer client.Close()
+	bus := NewRedisInvalidationBus(client, &RedisBusOptions{
+		MinBackoff:          10 * time.Millisecond,
+		MaxBackoff:          50 * time.Millisecond,
+		HealthCheckInterval: 50 * time.Millisecond,
+	})
+	defer bus.Close()
+
+	var mu sync.Mutex
+	var resets int
+	bus.Subscribe(func(event InvalidationEvent) {
+		if event.Kind == InvalidateReset {
+			mu.Lock()
+			defer mu.Unlock()
+			resets++
+		}
+	})
+	assert.Eventually(t, func() bool {
+		return bus.Status().Connected
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, bus.Status().Reconnects)
+
+	server.Close()
+	assert.Eventually(t, func() bool {
+		return !bus.Status().Connected
+	}, time.Second, 10*time.Millisecond)
+	assert.Error(t, bus.Status().LastError)
+	// Stay down for a few attempts
+	time.Sleep(100 * time.Millisecond)
+
+	assert.NoError(t, server.Restart())
+	assert.Eventually(t, func() bool {
+		return bus.Status().Connected
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, bus.Status().Reconnects)
+	mu.Lock()
+	assert.Equal(t, 1, resets)
+	mu.Unlock()
+
+	// Events are delivered again after the reconnect
+	received := make(chan InvalidationEvent, 1)
+	bus.Subscribe(func(event InvalidationEvent) {
+		if event.Kind == InvalidateKey {
+			received <- event
+		}
+	})
+	assert.NoError(t, bus.Publish(context.Background(), InvalidationEvent{Kind: InvalidateKey, Key: "key"}))
+	select {
+	case event := <-received:
+		assert.Equal(t, "key", event.Key)
+	case <-time.After(time.Second):
+		t.Fatal("event was not delivered after reconnect")
+	}
+}
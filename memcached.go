@@ -0,0 +1,690 @@
+package ginche
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// memcachedMaxKeyLength is the longest key memcached accepts
+	memcachedMaxKeyLength = 250
+	// memcachedMaxItemSize is the default largest item memcached stores
+	memcachedMaxItemSize = 1 << 20
+	// memcachedChunkSize is the payload size of a chunk, it leaves room for the key and item overhead
+	memcachedChunkSize = memcachedMaxItemSize - 1024
+	// memcachedMaxValueSize is the largest value stored in chunks, it bounds the chunk count of manifests
+	memcachedMaxValueSize = 1 << 30
+	// memcachedMaxChunks is the largest chunk count of a valid manifest
+	memcachedMaxChunks = (memcachedMaxValueSize + memcachedChunkSize - 1) / memcachedChunkSize
+	// memcachedHashedKeyMarker precedes the hash of keys that were too long, it can not appear in escaped keys
+	memcachedHashedKeyMarker = "%h"
+	// memcachedFlagChunked marks items holding a chunk manifest instead of a value
+	memcachedFlagChunked = 1
+	// memcachedRingReplicas is the number of points every server gets on the hash ring
+	memcachedRingReplicas = 160
+	// memcachedMaxRelativeTTL is the longest TTL memcached treats as relative, longer ones must be timestamps
+	memcachedMaxRelativeTTL = 30 * 24 * time.Hour
+)
+
+var (
+	errMemcachedMiss     = errors.New("ginche: memcached cache miss")
+	errMemcachedNoServer = errors.New("ginche: no memcached servers")
+	errMemcachedTooLarge = errors.New("ginche: value too large for memcached")
+	errMemcachedManifest = errors.New("ginche: malformed memcached chunk manifest")
+)
+
+// MemcachedOptions is the options for the memcached adapter
+// Servers is the list of memcached addresses, keys are spread between them by consistent hashing
+// MaxIdleConns is the number of idle connections kept per server, defaults to 2
+// DialTimeout is the timeout for opening a connection, defaults to 1 second
+// Timeout is the timeout for a single operation, defaults to 1 second
+type MemcachedOptions struct {
+	Servers      []string
+	MaxIdleConns int
+	DialTimeout  time.Duration
+	Timeout      time.Duration
+}
+
+// MemcachedAdapter stores items in memcached using the text protocol.
+// Keys longer than 250 bytes or containing whitespace or control characters
+// are escaped, or replaced by their hash if they are still too long.
+// Values larger than 1 MB are split into chunks.
+// Find and Scan list keys with "lru_crawler metadump", which needs memcached 1.4.31 or newer,
+// and do not return keys that had to be hashed.
+type MemcachedAdapter struct {
+	servers []*memcachedServer
+	ring    []memcachedRingPoint
+	prefix  string
+	ttl     time.Duration
+	codec   *valueCodec
+}
+
+// NewMemcachedAdapter creates a memcached adapter for the given servers.
+// If TTL is nil, it will default to 5 minutes.
+func NewMemcachedAdapter(options *MemcachedOptions, config ...CacheConfig) (CacheAdapter, error) {
+	if options == nil || len(options.Servers) == 0 {
+		return nil, errMemcachedNoServer
+	}
+	opts := *options
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = 2
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = time.Second
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Second
+	}
+	var conf CacheConfig
+	if config != nil {
+		conf = config[0]
+	}
+	m := &MemcachedAdapter{ttl: 5 * time.Minute, codec: newValueCodec(conf)}
+	if conf.TTL != nil {
+		m.ttl = *conf.TTL
+	}
+	if conf.Namespace != "" {
+		m.prefix = conf.Namespace + ":"
+	}
+	for _, addr := range opts.Servers {
+		m.servers = append(m.servers, &memcachedServer{
+			addr:        addr,
+			maxIdle:     opts.MaxIdleConns,
+			dialTimeout: opts.DialTimeout,
+			timeout:     opts.Timeout,
+		})
+	}
+	m.ring = newMemcachedRing(m.servers)
+	if err := pingWithTimeout(m, conf.PingTimeout); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *MemcachedAdapter) Set(key *string, value interface{}, config ...*ItemConfig) {
+	ttl := m.ttl
+	if config != nil && config[0].TTL != nil {
+		ttl = *config[0].TTL
+	}
+	val, err := json.Marshal(item{Data: value})
+	if err != nil {
+		return
+	}
+	_ = m.set(*key, m.codec.seal(m.prefix+*key, val), ttl)
+}
+
+// decode verifies, decompresses and deserializes a value of the key written by Set.
+// Entries that can not be decoded are reported as corrupted and deleted if VerifyIntegrity is enabled.
+func (m *MemcachedAdapter) decode(key string, value []byte) (interface{}, bool) {
+	value, err := m.codec.open(m.prefix+key, value)
+	if err == nil {
+		var data item
+		if err = json.Unmarshal(value, &data); err == nil {
+			return data.Data, true
+		}
+	}
+	if m.codec.corrupted(key, err) {
+		m.Delete(key)
+	}
+	return nil, false
+}
+
+// CompressionStats reports how well the values written by the adapter compress.
+func (m *MemcachedAdapter) CompressionStats() CompressionStats {
+	return m.codec.stats()
+}
+
+// set stores the value, splitting it into chunks if it does not fit into a single item.
+// Chunks are written before the manifest pointing at them, and every write gets
+// its own chunk keys, so readers never see a mix of two writes.
+func (m *MemcachedAdapter) set(key string, value []byte, ttl time.Duration) error {
+	exptime := memcachedExptime(ttl)
+	if len(value) > memcachedMaxValueSize {
+		return errMemcachedTooLarge
+	}
+	if len(value) <= memcachedChunkSize {
+		return m.store(m.key(key), 0, exptime, value)
+	}
+	id, err := randomID()
+	if err != nil {
+		return err
+	}
+	count := (len(value) + memcachedChunkSize - 1) / memcachedChunkSize
+	for i := 0; i < count; i++ {
+		end := (i + 1) * memcachedChunkSize
+		if end > len(value) {
+			end = len(value)
+		}
+		if err := m.store(m.chunkKey(key, id, i), 0, exptime, value[i*memcachedChunkSize:end]); err != nil {
+			return err
+		}
+	}
+	return m.store(m.key(key), memcachedFlagChunked, exptime, []byte(fmt.Sprintf("%s %d", id, count)))
+}
+
+func (m *MemcachedAdapter) Get(key string) (interface{}, bool) {
+	value, err := m.get(key)
+	if err != nil {
+		return nil, false
+	}
+	return m.decode(key, value)
+}
+
+// get returns the value of the key, joining its chunks if it was split.
+func (m *MemcachedAdapter) get(key string) ([]byte, error) {
+	k := m.key(key)
+	items, err := m.getMulti([]string{k})
+	if err != nil {
+		return nil, err
+	}
+	it, ok := items[k]
+	if !ok {
+		return nil, errMemcachedMiss
+	}
+	if it.flags&memcachedFlagChunked == 0 {
+		return it.data, nil
+	}
+
+	var id string
+	var count int
+	if _, err := fmt.Sscanf(string(it.data), "%s %d", &id, &count); err != nil || count <= 0 || count > memcachedMaxChunks {
+		// Manifests are not sealed, so broken ones are reported here like broken values in decode
+		if m.codec.corrupted(key, errMemcachedManifest) {
+			m.Delete(key)
+		}
+		return nil, errMemcachedManifest
+	}
+	keys := make([]string, count)
+	for i := range keys {
+		keys[i] = m.chunkKey(key, id, i)
+	}
+	chunks, err := m.getMulti(keys)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, k := range keys {
+		chunk, ok := chunks[k]
+		if !ok {
+			return nil, errMemcachedMiss
+		}
+		buf.Write(chunk.data)
+	}
+	return buf.Bytes(), nil
+}
+
+// GetMulti fetches the keys with a single get command per server.
+// Chunked values are fetched separately.
+func (m *MemcachedAdapter) GetMulti(keys []string) map[string]interface{} {
+	sanitized := make([]string, len(keys))
+	for i, key := range keys {
+		sanitized[i] = m.key(key)
+	}
+	items, err := m.getMulti(sanitized)
+	if err != nil {
+		return map[string]interface{}{}
+	}
+	values := make(map[string]interface{}, len(items))
+	for i, key := range keys {
+		it, ok := items[sanitized[i]]
+		if !ok {
+			continue
+		}
+		value := it.data
+		if it.flags&memcachedFlagChunked != 0 {
+			if value, err = m.get(key); err != nil {
+				continue
+			}
+		}
+		if data, ok := m.decode(key, value); ok {
+			values[key] = data
+		}
+	}
+	return values
+}
+
+// SetMulti stores the items one by one, memcached has no multi-key set.
+func (m *MemcachedAdapter) SetMulti(items map[string]interface{}, config ...*ItemConfig) {
+	for key, value := range items {
+		key := key
+		m.Set(&key, value, config...)
+	}
+}
+
+// DeleteMulti deletes the keys one by one, memcached has no multi-key delete.
+func (m *MemcachedAdapter) DeleteMulti(keys []string) {
+	for _, key := range keys {
+		m.Delete(key)
+	}
+}
+
+func (m *MemcachedAdapter) Delete(key string) {
+	k := m.key(key)
+	_ = m.serverFor(k).do(func(c *memcachedConn) error {
+		line, err := c.command("delete %s", k)
+		if err != nil {
+			return err
+		}
+		if line != "DELETED" && line != "NOT_FOUND" {
+			return memcachedError(line)
+		}
+		return nil
+	})
+}
+
+// Find returns all keys matching the glob pattern.
+func (m *MemcachedAdapter) Find(pattern string) []string {
+	keys, _, _ := m.Scan(context.Background(), pattern, 0, 0)
+	return keys
+}
+
+// Scan returns keys matching the glob pattern, one server per call.
+// Count is ignored, as memcached dumps all keys of a server at once.
+func (m *MemcachedAdapter) Scan(ctx context.Context, pattern string, cursor uint64, count int64) ([]string, uint64, error) {
+	g := compileGlob(pattern)
+	var keys []string
+	for i := cursor; i < uint64(len(m.servers)); i++ {
+		if err := ctx.Err(); err != nil {
+			return keys, i, err
+		}
+		dumped, err := m.servers[i].dumpKeys()
+		if err != nil {
+			return keys, i, err
+		}
+		for _, k := range dumped {
+			key, ok := m.originalKey(k)
+			if ok && g.match(key) {
+				keys = append(keys, key)
+			}
+		}
+		if count > 0 && i+1 < uint64(len(m.servers)) {
+			return keys, i + 1, nil
+		}
+	}
+	return keys, 0, nil
+}
+
+// FlushAll deletes all keys of the namespace.
+// Without a namespace it does nothing, since the keys of the cache can not be
+// told apart from the data of other applications using the same servers.
+func (m *MemcachedAdapter) FlushAll() {
+	if m.prefix == "" {
+		return
+	}
+	prefix := escapeMemcachedKey(m.prefix)
+	for _, s := range m.servers {
+		keys, err := s.dumpKeys()
+		if err != nil {
+			continue
+		}
+		for _, k := range keys {
+			if strings.HasPrefix(k, prefix) {
+				_ = s.do(func(c *memcachedConn) error {
+					_, err := c.command("delete %s", k)
+					return err
+				})
+			}
+		}
+	}
+}
+
+// Ping checks that every server answers the version command.
+func (m *MemcachedAdapter) Ping(ctx context.Context) error {
+	for _, s := range m.servers {
+		err := s.do(func(c *memcachedConn) error {
+			if deadline, ok := ctx.Deadline(); ok {
+				if err := c.nc.SetDeadline(deadline); err != nil {
+					return err
+				}
+			}
+			line, err := c.command("version")
+			if err != nil {
+				return err
+			}
+			if !strings.HasPrefix(line, "VERSION ") {
+				return memcachedError(line)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.addr, err)
+		}
+	}
+	return nil
+}
+
+// Close closes all idle connections.
+func (m *MemcachedAdapter) Close() error {
+	for _, s := range m.servers {
+		s.close()
+	}
+	return nil
+}
+
+// key returns the memcached key for the cache key.
+func (m *MemcachedAdapter) key(key string) string {
+	return sanitizeMemcachedKey(m.prefix, key)
+}
+
+// chunkKey returns the memcached key of a chunk of the value written with the given id.
+// It contains a NUL byte, so it can never collide with a cache key.
+func (m *MemcachedAdapter) chunkKey(key, id string, i int) string {
+	return sanitizeMemcachedKey(m.prefix, fmt.Sprintf("%s\x00%s\x00%d", key, id, i))
+}
+
+// originalKey converts a dumped memcached key back to the cache key.
+// It reports false for keys of other namespaces, chunks and hashed keys.
+func (m *MemcachedAdapter) originalKey(k string) (string, bool) {
+	if strings.Contains(k, memcachedHashedKeyMarker) {
+		return "", false
+	}
+	key, err := url.PathUnescape(k)
+	if err != nil || !strings.HasPrefix(key, m.prefix) || strings.Contains(key, "\x00") {
+		return "", false
+	}
+	return strings.TrimPrefix(key, m.prefix), true
+}
+
+// store runs a set command for an already sanitized key.
+func (m *MemcachedAdapter) store(key string, flags uint32, exptime int64, value []byte) error {
+	return m.serverFor(key).do(func(c *memcachedConn) error {
+		if _, err := fmt.Fprintf(c.rw, "set %s %d %d %d\r\n", key, flags, exptime, len(value)); err != nil {
+			return err
+		}
+		if _, err := c.rw.Write(value); err != nil {
+			return err
+		}
+		line, err := c.command("")
+		if err != nil {
+			return err
+		}
+		if line != "STORED" {
+			return memcachedError(line)
+		}
+		return nil
+	})
+}
+
+// getMulti fetches sanitized keys, grouped by server.
+func (m *MemcachedAdapter) getMulti(keys []string) (map[string]memcachedItem, error) {
+	byServer := make(map[*memcachedServer][]string)
+	for _, k := range keys {
+		s := m.serverFor(k)
+		byServer[s] = append(byServer[s], k)
+	}
+	items := make(map[string]memcachedItem, len(keys))
+	for s, keys := range byServer {
+		err := s.do(func(c *memcachedConn) error {
+			if _, err := fmt.Fprintf(c.rw, "get %s\r\n", strings.Join(keys, " ")); err != nil {
+				return err
+			}
+			if err := c.rw.Flush(); err != nil {
+				return err
+			}
+			return c.readValues(items)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// serverFor returns the server owning the sanitized key on the hash ring.
+func (m *MemcachedAdapter) serverFor(key string) *memcachedServer {
+	h := memcachedHash(key)
+	i := sort.Search(len(m.ring), func(i int) bool { return m.ring[i].hash >= h })
+	if i == len(m.ring) {
+		i = 0
+	}
+	return m.ring[i].server
+}
+
+// memcachedRingPoint is a point of a server on the consistent hash ring.
+type memcachedRingPoint struct {
+	hash   uint64
+	server *memcachedServer
+}
+
+// newMemcachedRing places memcachedRingReplicas points per server on the ring,
+// so adding or removing a server only moves the keys next to its points.
+func newMemcachedRing(servers []*memcachedServer) []memcachedRingPoint {
+	ring := make([]memcachedRingPoint, 0, len(servers)*memcachedRingReplicas)
+	for _, s := range servers {
+		for i := 0; i < memcachedRingReplicas; i++ {
+			ring = append(ring, memcachedRingPoint{hash: memcachedHash(fmt.Sprintf("%s-%d", s.addr, i)), server: s})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	return ring
+}
+
+// memcachedHash is FNV-1a with a final mix, so similar keys spread over the ring.
+func memcachedHash(key string) uint64 {
+	h, _ := hashKey(key)
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// sanitizeMemcachedKey returns a valid memcached key for the namespace prefix and key.
+// Keys that are longer than 250 bytes once escaped are replaced by their SHA-256 hash,
+// keeping the prefix, so they can still be found by namespace.
+func sanitizeMemcachedKey(prefix, key string) string {
+	escaped := escapeMemcachedKey(prefix + key)
+	if len(escaped) <= memcachedMaxKeyLength {
+		return escaped
+	}
+	sum := sha256.Sum256([]byte(prefix + key))
+	return escapeMemcachedKey(prefix) + memcachedHashedKeyMarker + hex.EncodeToString(sum[:])
+}
+
+// escapeMemcachedKey escapes "%", whitespace and control characters as %XX.
+func escapeMemcachedKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c <= ' ' || c == 0x7f || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// memcachedExptime converts a TTL to memcached exptime.
+// A TTL of 0 means the item never expires, see ItemConfig.
+// TTLs above 30 days are sent as unix timestamps, sub-second TTLs are rounded up,
+// as 0 would mean the item never expires.
+func memcachedExptime(ttl time.Duration) int64 {
+	if ttl == 0 {
+		return 0
+	}
+	if ttl > memcachedMaxRelativeTTL {
+		return time.Now().Add(ttl).Unix()
+	}
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// memcachedError converts an error reply to an error.
+func memcachedError(line string) error {
+	return fmt.Errorf("ginche: memcached: %s", line)
+}
+
+type memcachedItem struct {
+	flags uint32
+	data  []byte
+}
+
+// memcachedServer is a memcached server with a pool of idle connections.
+type memcachedServer struct {
+	addr        string
+	maxIdle     int
+	dialTimeout time.Duration
+	timeout     time.Duration
+	mu          sync.Mutex
+	idle        []*memcachedConn
+}
+
+// do runs fn on a pooled connection.
+// Connections are only returned to the pool if fn succeeds, as a failed
+// command can leave unread replies behind.
+func (s *memcachedServer) do(fn func(c *memcachedConn) error) error {
+	c, err := s.conn()
+	if err != nil {
+		return err
+	}
+	if err := c.nc.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		c.nc.Close()
+		return err
+	}
+	if err := fn(c); err != nil {
+		c.nc.Close()
+		return err
+	}
+	s.release(c)
+	return nil
+}
+
+func (s *memcachedServer) conn() (*memcachedConn, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		c := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return c, nil
+	}
+	s.mu.Unlock()
+	nc, err := net.DialTimeout("tcp", s.addr, s.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &memcachedConn{nc: nc, rw: bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))}, nil
+}
+
+func (s *memcachedServer) release(c *memcachedConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.idle) >= s.maxIdle {
+		c.nc.Close()
+		return
+	}
+	s.idle = append(s.idle, c)
+}
+
+func (s *memcachedServer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.idle {
+		c.nc.Close()
+	}
+	s.idle = nil
+}
+
+// dumpKeys returns all keys stored on the server, as reported by lru_crawler metadump.
+func (s *memcachedServer) dumpKeys() ([]string, error) {
+	var keys []string
+	err := s.do(func(c *memcachedConn) error {
+		keys = keys[:0]
+		line, err := c.command("lru_crawler metadump all")
+		for ; err == nil && line != "END"; line, err = c.readLine() {
+			if strings.HasPrefix(line, "ERROR") || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+				return memcachedError(line)
+			}
+			for _, field := range strings.Fields(line) {
+				if k := strings.TrimPrefix(field, "key="); k != field {
+					k, err := url.QueryUnescape(k)
+					if err != nil {
+						return err
+					}
+					keys = append(keys, k)
+				}
+			}
+		}
+		return err
+	})
+	return keys, err
+}
+
+// memcachedConn is a connection speaking the memcached text protocol.
+type memcachedConn struct {
+	nc net.Conn
+	rw *bufio.ReadWriter
+}
+
+// command sends a command line, if it is not empty, and reads a single reply line.
+func (c *memcachedConn) command(format string, args ...interface{}) (string, error) {
+	if format != "" {
+		if _, err := fmt.Fprintf(c.rw, format, args...); err != nil {
+			return "", err
+		}
+	}
+	if _, err := c.rw.WriteString("\r\n"); err != nil {
+		return "", err
+	}
+	if err := c.rw.Flush(); err != nil {
+		return "", err
+	}
+	return c.readLine()
+}
+
+func (c *memcachedConn) readLine() (string, error) {
+	line, err := c.rw.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readValues reads VALUE replies of a get command until END.
+func (c *memcachedConn) readValues(items map[string]memcachedItem) error {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "END" {
+			return nil
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != "VALUE" {
+			return memcachedError(line)
+		}
+		flags, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return err
+		}
+		size, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.rw, data); err != nil {
+			return err
+		}
+		items[fields[1]] = memcachedItem{flags: uint32(flags), data: data[:size]}
+	}
+}
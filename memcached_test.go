@@ -0,0 +1,511 @@
+package ginche
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMemcached is an in-process server speaking the memcached text protocol.
+// It supports the commands used by MemcachedAdapter.
+type fakeMemcached struct {
+	listener net.Listener
+	mu       sync.Mutex
+	items    map[string]fakeMemcachedItem
+	conns    int
+}
+
+type fakeMemcachedItem struct {
+	flags     uint32
+	data      []byte
+	expiresAt time.Time
+}
+
+func newFakeMemcached() (*fakeMemcached, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	f := &fakeMemcached{listener: l, items: make(map[string]fakeMemcachedItem)}
+	go f.serve()
+	return f, nil
+}
+
+func (f *fakeMemcached) Addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeMemcached) Close() {
+	f.listener.Close()
+}
+
+func (f *fakeMemcached) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns++
+		f.mu.Unlock()
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeMemcached) handle(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			fmt.Fprint(rw, "ERROR\r\n")
+			rw.Flush()
+			continue
+		}
+		switch fields[0] {
+		case "set":
+			if err := f.set(rw, fields); err != nil {
+				return
+			}
+		case "get":
+			f.get(rw, fields[1:])
+		case "delete":
+			f.mu.Lock()
+			if _, ok := f.lookup(fields[1]); ok {
+				delete(f.items, fields[1])
+				fmt.Fprint(rw, "DELETED\r\n")
+			} else {
+				fmt.Fprint(rw, "NOT_FOUND\r\n")
+			}
+			f.mu.Unlock()
+		case "flush_all":
+			f.mu.Lock()
+			f.items = make(map[string]fakeMemcachedItem)
+			f.mu.Unlock()
+			fmt.Fprint(rw, "OK\r\n")
+		case "lru_crawler":
+			f.mu.Lock()
+			for k := range f.items {
+				if _, ok := f.lookup(k); ok {
+					fmt.Fprintf(rw, "key=%s exp=-1 la=0 cas=0 fetch=no cls=1 size=1\r\n", url.QueryEscape(k))
+				}
+			}
+			f.mu.Unlock()
+			fmt.Fprint(rw, "END\r\n")
+		case "version":
+			fmt.Fprint(rw, "VERSION 1.6.0\r\n")
+		default:
+			fmt.Fprint(rw, "ERROR\r\n")
+		}
+		rw.Flush()
+	}
+}
+
+func (f *fakeMemcached) set(rw *bufio.ReadWriter, fields []string) error {
+	if len(fields) != 5 || len(fields[1]) > memcachedMaxKeyLength {
+		fmt.Fprint(rw, "CLIENT_ERROR bad command line format\r\n")
+		return nil
+	}
+	flags, _ := strconv.ParseUint(fields[2], 10, 32)
+	exptime, _ := strconv.ParseInt(fields[3], 10, 64)
+	size, _ := strconv.Atoi(fields[4])
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(rw, data); err != nil {
+		return err
+	}
+	if size > memcachedMaxItemSize {
+		fmt.Fprint(rw, "SERVER_ERROR object too large for cache\r\n")
+		return nil
+	}
+	var expiresAt time.Time
+	if exptime > int64(memcachedMaxRelativeTTL/time.Second) {
+		expiresAt = time.Unix(exptime, 0)
+	} else if exptime != 0 {
+		expiresAt = time.Now().Add(time.Duration(exptime) * time.Second)
+	}
+	f.mu.Lock()
+	f.items[fields[1]] = fakeMemcachedItem{flags: uint32(flags), data: data[:size], expiresAt: expiresAt}
+	f.mu.Unlock()
+	fmt.Fprint(rw, "STORED\r\n")
+	return nil
+}
+
+func (f *fakeMemcached) get(rw *bufio.ReadWriter, keys []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, k := range keys {
+		if it, ok := f.lookup(k); ok {
+			fmt.Fprintf(rw, "VALUE %s %d %d\r\n", k, it.flags, len(it.data))
+			rw.Write(it.data)
+			fmt.Fprint(rw, "\r\n")
+		}
+	}
+	fmt.Fprint(rw, "END\r\n")
+}
+
+// lookup returns the item if it exists and has not expired. The caller must hold f.mu.
+func (f *fakeMemcached) lookup(key string) (fakeMemcachedItem, bool) {
+	it, ok := f.items[key]
+	if !ok || (!it.expiresAt.IsZero() && time.Now().After(it.expiresAt)) {
+		return it, false
+	}
+	return it, true
+}
+
+type MemcachedSuite struct {
+	suite.Suite
+	servers []*fakeMemcached
+	store   CacheAdapter
+}
+
+func (s *MemcachedSuite) SetupTest() {
+	s.servers = nil
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		server, err := newFakeMemcached()
+		s.Require().NoError(err)
+		s.servers = append(s.servers, server)
+		addrs = append(addrs, server.Addr())
+	}
+	var err error
+	s.store, err = NewMemcachedAdapter(&MemcachedOptions{Servers: addrs})
+	s.Require().NoError(err)
+}
+
+func (s *MemcachedSuite) TearDownTest() {
+	s.NoError(s.store.Close())
+	for _, server := range s.servers {
+		server.Close()
+	}
+}
+
+func (s *MemcachedSuite) TestSet() {
+	key := "test_key"
+	value := "test_value"
+	s.store.Set(&key, value)
+	returnedValue, ok := s.store.Get(key)
+	s.True(ok)
+	s.Equal(value, returnedValue)
+
+	s.store.Delete(key)
+	_, ok = s.store.Get(key)
+	s.False(ok)
+}
+
+func (s *MemcachedSuite) TestSetWithConfig() {
+	key := "test_key"
+	s.store.Set(&key, "test_value", &ItemConfig{TTL: Duration(time.Second)})
+	_, ok := s.store.Get(key)
+	s.True(ok)
+	time.Sleep(1100 * time.Millisecond)
+	_, ok = s.store.Get(key)
+	s.False(ok)
+}
+
+func (s *MemcachedSuite) TestKeysAreSpreadBetweenServers() {
+	for i := 0; i < 300; i++ {
+		s.store.Set(String(fmt.Sprintf("/users/%d", i)), i)
+	}
+	for _, server := range s.servers {
+		server.mu.Lock()
+		s.Greater(len(server.items), 50)
+		server.mu.Unlock()
+	}
+	s.Len(s.store.Find("*"), 300)
+}
+
+func (s *MemcachedSuite) TestSanitizedKeys() {
+	keys := []string{
+		"/search?q=hello world",
+		"/tab\tnew\nline",
+		"/percent%20",
+		"/long/" + strings.Repeat("a", 300),
+	}
+	for i, key := range keys {
+		s.store.Set(String(key), i)
+	}
+	for i, key := range keys {
+		d, ok := s.store.Get(key)
+		s.True(ok, key)
+		s.Equal(float64(i), d)
+	}
+	// Hashed keys can not be listed
+	s.ElementsMatch(keys[:3], s.store.Find("*"))
+	s.ElementsMatch([]string{keys[0]}, s.store.Find("/search*"))
+}
+
+func (s *MemcachedSuite) TestChunkedValues() {
+	value := strings.Repeat("0123456789", 350000)
+	s.store.Set(String("large"), value)
+	d, ok := s.store.Get("large")
+	s.True(ok)
+	s.Equal(value, d)
+	s.Equal([]string{"large"}, s.store.Find("*"))
+
+	// Losing a chunk turns the item into a miss
+	for _, server := range s.servers {
+		server.mu.Lock()
+		for k, it := range server.items {
+			if it.flags == 0 {
+				delete(server.items, k)
+			}
+		}
+		server.mu.Unlock()
+	}
+	_, ok = s.store.Get("large")
+	s.False(ok)
+}
+
+func (s *MemcachedSuite) TestFlushAllNamespace() {
+	addrs := []string{s.servers[0].Addr(), s.servers[1].Addr()}
+	first, _ := NewMemcachedAdapter(&MemcachedOptions{Servers: addrs}, CacheConfig{Namespace: "first"})
+	defer first.Close()
+	second, _ := NewMemcachedAdapter(&MemcachedOptions{Servers: addrs}, CacheConfig{Namespace: "second"})
+	defer second.Close()
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key %d", i)
+		first.Set(&key, i)
+		second.Set(&key, i)
+	}
+	first.Set(String(strings.Repeat("a", 300)), "hashed")
+	s.Len(first.Find("key*"), 20)
+
+	first.FlushAll()
+	s.Empty(first.Find("*"))
+	_, ok := first.Get(strings.Repeat("a", 300))
+	s.False(ok)
+	s.Len(second.Find("*"), 20)
+
+	second.FlushAll()
+	s.Empty(second.Find("*"))
+}
+
+func (s *MemcachedSuite) TestBrokenManifests() {
+	var reported []string
+	store, err := NewMemcachedAdapter(&MemcachedOptions{Servers: []string{s.servers[0].Addr()}}, CacheConfig{
+		VerifyIntegrity:  true,
+		OnIntegrityError: func(key string, err error) { reported = append(reported, key) },
+	})
+	s.Require().NoError(err)
+	defer store.Close()
+
+	server := s.servers[0]
+	for _, manifest := range []string{"abc -1", "abc 0", "abc 1000000000", "abc"} {
+		server.mu.Lock()
+		server.items["key"] = fakeMemcachedItem{flags: memcachedFlagChunked, data: []byte(manifest)}
+		server.mu.Unlock()
+
+		_, ok := store.Get("key")
+		s.False(ok, manifest)
+		server.mu.Lock()
+		_, exists := server.items["key"]
+		server.mu.Unlock()
+		s.False(exists, "broken manifests are deleted")
+	}
+	s.Equal([]string{"key", "key", "key", "key"}, reported)
+
+	server.mu.Lock()
+	server.items["key"] = fakeMemcachedItem{flags: memcachedFlagChunked, data: []byte("abc -1")}
+	server.mu.Unlock()
+	s.Empty(GetMulti(store, []string{"key"}))
+}
+
+func (s *MemcachedSuite) TestFlushAllWithoutNamespace() {
+	other, _ := NewMemcachedAdapter(&MemcachedOptions{Servers: []string{s.servers[0].Addr()}}, CacheConfig{Namespace: "app"})
+	defer other.Close()
+	other.Set(String("session"), "data")
+	s.store.Set(String("key"), "value")
+
+	s.store.FlushAll()
+	d, ok := other.Get("session")
+	s.True(ok)
+	s.Equal("data", d)
+	_, ok = s.store.Get("key")
+	s.True(ok)
+}
+
+func (s *MemcachedSuite) TestSetWithoutExpiry() {
+	s.store.Set(String("key"), "value", &ItemConfig{TTL: Duration(0)})
+	d, ok := s.store.Get("key")
+	s.True(ok)
+	s.Equal("value", d)
+	for _, server := range s.servers {
+		server.mu.Lock()
+		if it, ok := server.items["key"]; ok {
+			s.True(it.expiresAt.IsZero())
+		}
+		server.mu.Unlock()
+	}
+}
+
+func (s *MemcachedSuite) TestScan() {
+	for i := 0; i < 30; i++ {
+		s.store.Set(String(fmt.Sprintf("/users/%d", i)), i)
+	}
+	var keys []string
+	var cursor uint64
+	for {
+		batch, next, err := s.store.(Scanner).Scan(context.Background(), "/users/*", cursor, 10)
+		s.NoError(err)
+		keys = append(keys, batch...)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	s.Len(keys, 30)
+}
+
+func (s *MemcachedSuite) TestConnectionPool() {
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("key%d", g*50+i)
+				s.store.Set(&key, i)
+				s.store.Get(key)
+			}
+		}(g)
+	}
+	wg.Wait()
+	conns := 0
+	for _, server := range s.servers {
+		server.mu.Lock()
+		conns += server.conns
+		server.mu.Unlock()
+	}
+	// Connections are reused instead of being opened per command
+	s.Less(conns, 8*50)
+}
+
+func (s *MemcachedSuite) TestUnreachableServer() {
+	store, err := NewMemcachedAdapter(&MemcachedOptions{Servers: []string{"127.0.0.1:1"}, DialTimeout: 100 * time.Millisecond})
+	s.NoError(err)
+	defer store.Close()
+	store.Set(String("key"), "value")
+	_, ok := store.Get("key")
+	s.False(ok)
+
+	_, err = NewMemcachedAdapter(&MemcachedOptions{})
+	s.Error(err)
+}
+
+func (s *MemcachedSuite) TestWithMiddleware() {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware(s.store, &Options{}))
+	r.GET("/test", func(ctx *gin.Context) {
+		ctx.JSON(200, gin.H{"data": "test"})
+	})
+	for _, status := range []string{HeaderXCacheMiss, HeaderXCacheHit} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/test", nil)
+		r.ServeHTTP(w, req)
+		s.Equal("{\"data\":\"test\"}", w.Body.String())
+		s.Equal(status, w.Header().Get(HeaderXCache))
+	}
+}
+
+func (s *MemcachedSuite) TestMulti() {
+	large := strings.Repeat("0123456789", 150000)
+	SetMulti(s.store, map[string]interface{}{"a": "1", "b": "2", "large": large})
+	values := GetMulti(s.store, []string{"a", "b", "large", "missing"})
+	s.Equal(map[string]interface{}{"a": "1", "b": "2", "large": large}, values)
+
+	DeleteMulti(s.store, []string{"a", "large"})
+	s.Equal(map[string]interface{}{"b": "2"}, GetMulti(s.store, []string{"a", "b", "large"}))
+}
+
+func TestMemcachedExptime(t *testing.T) {
+	if got := memcachedExptime(0); got != 0 {
+		t.Errorf("memcachedExptime(0) = %v, want 0", got)
+	}
+	if got := memcachedExptime(time.Millisecond); got != 1 {
+		t.Errorf("memcachedExptime(1ms) = %v, want 1", got)
+	}
+	if got := memcachedExptime(90 * time.Second); got != 90 {
+		t.Errorf("memcachedExptime(90s) = %v, want 90", got)
+	}
+	if got := memcachedExptime(60 * 24 * time.Hour); got < time.Now().Unix() {
+		t.Errorf("memcachedExptime(60d) = %v, want a unix timestamp", got)
+	}
+}
+
+func TestMemcachedSuite(t *testing.T) {
+	suite.Run(t, new(MemcachedSuite))
+}
+
+func (s *MemcachedSuite) TestCompression() {
+	value := strings.Repeat("compressible ", 1000)
+	s.store.Set(String("plain"), value)
+
+	compressed, err := NewMemcachedAdapter(&MemcachedOptions{Servers: []string{s.servers[0].Addr(), s.servers[1].Addr(), s.servers[2].Addr()}},
+		CacheConfig{Compression: CompressionFlate})
+	s.Require().NoError(err)
+	defer compressed.Close()
+	compressed.Set(String("small"), "value")
+	compressed.Set(String("large"), value)
+
+	// Values written with and without compression are read by both adapters
+	for _, store := range []CacheAdapter{s.store, compressed} {
+		values := GetMulti(store, []string{"plain", "small", "large"})
+		s.Equal(map[string]interface{}{"plain": value, "small": "value", "large": value}, values)
+		d, ok := store.Get("large")
+		s.True(ok)
+		s.Equal(value, d)
+	}
+
+	stats := compressed.(*MemcachedAdapter).CompressionStats()
+	s.Equal(uint64(2), stats.Values)
+	s.Equal(uint64(1), stats.Compressed)
+	s.Less(stats.Ratio, 0.1)
+	s.Equal(uint64(0), s.store.(*MemcachedAdapter).CompressionStats().Compressed)
+}
+
+func (s *MemcachedSuite) TestIntegrity() {
+	var reported []string
+	store, err := NewMemcachedAdapter(&MemcachedOptions{Servers: []string{s.servers[0].Addr()}}, CacheConfig{
+		IntegritySecret:  []byte("secret"),
+		OnIntegrityError: func(key string, err error) { reported = append(reported, key) },
+	})
+	s.Require().NoError(err)
+	defer store.Close()
+
+	store.Set(String("key"), "value")
+	d, ok := store.Get("key")
+	s.True(ok)
+	s.Equal("value", d)
+
+	server := s.servers[0]
+	server.mu.Lock()
+	it := server.items["key"]
+	it.data = []byte(strings.Replace(string(it.data), "value", "evil!", 1))
+	server.items["key"] = it
+	server.mu.Unlock()
+
+	_, ok = store.Get("key")
+	s.False(ok)
+	s.Equal([]string{"key"}, reported)
+	server.mu.Lock()
+	_, exists := server.items["key"]
+	server.mu.Unlock()
+	s.False(exists, "corrupted entries are deleted")
+}
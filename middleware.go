@@ -62,23 +62,24 @@ func Middleware(storage CacheAdapter, options *Options) gin.HandlerFunc {
 			}
 		}
 
+		if notModified(ctx, storage, cacheKey, options) {
+			ctx.Abort()
+			return
+		}
 		if data, ok := storage.Get(cacheKey); ok {
-			entry, ok := data.(*httpCacheItem)
-			if !ok {
-				b, _ := json.Marshal(data)
-				var item httpCacheItem
-				_ = json.Unmarshal(b, &item)
-				entry = &item
-			}
-			for k, h := range entry.Headers {
-				for _, v := range h {
-					ctx.Writer.Header().Add(k, v)
+			if entry, body, ok := cachedResponse(data); ok {
+				for k, h := range entry.Headers {
+					for _, v := range h {
+						ctx.Writer.Header().Add(k, v)
+					}
 				}
+				ctx.Writer.Header().Set(HeaderXCache, HeaderXCacheHit)
+				ctx.String(entry.Status, body)
+				ctx.Abort()
+				return
 			}
-			ctx.Writer.Header().Set(HeaderXCache, HeaderXCacheHit)
-			ctx.String(entry.Status, entry.Data.(string))
-			ctx.Abort()
-			return
+			// Entries that are not responses are broken, they are deleted and the request is served as a miss
+			storage.Delete(cacheKey)
 		}
 		w := &writer{body: &bytes.Buffer{}, ResponseWriter: ctx.Writer}
 		ctx.Writer = w
@@ -102,11 +103,15 @@ func Middleware(storage CacheAdapter, options *Options) gin.HandlerFunc {
 // ExcludeStatuses is the list of status codes to exclude from the cache
 // ExcludeMethods is the list of methods to exclude from the cache
 // ExcludePaths is the list of paths to exclude from the cache
+// ConditionalRequests answers If-None-Match with 304 Not Modified using any storage implementing
+// ResponseMetaGetter. Without it, only RedisAdapter with CacheConfig.HashResponses does so,
+// since it reads the metadata without the body.
 type Options struct {
-	KeyFunc         func(c *gin.Context) string
-	ExcludeStatuses []int
-	ExcludeMethods  []string
-	ExcludePaths    []string
+	KeyFunc             func(c *gin.Context) string
+	ExcludeStatuses     []int
+	ExcludeMethods      []string
+	ExcludePaths        []string
+	ConditionalRequests bool
 }
 
 type httpCacheItem struct {
@@ -115,6 +120,83 @@ type httpCacheItem struct {
 	Data    interface{}
 }
 
+// cachedResponse returns the response and its body stored in a cache entry,
+// or false if the entry is not a response with a string body.
+func cachedResponse(value interface{}) (*httpCacheItem, string, bool) {
+	item, ok := asHTTPCacheItem(value)
+	if !ok {
+		return nil, "", false
+	}
+	body, ok := item.Data.(string)
+	return item, body, ok
+}
+
+// asHTTPCacheItem converts values decoded from JSON back to a response.
+func asHTTPCacheItem(value interface{}) (*httpCacheItem, bool) {
+	switch v := value.(type) {
+	case *httpCacheItem:
+		return v, true
+	case httpCacheItem:
+		return &v, true
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, false
+	}
+	var item httpCacheItem
+	if err := json.Unmarshal(b, &item); err != nil || item.Status == 0 {
+		return nil, false
+	}
+	return &item, true
+}
+
+// notModifiedHeaders are the headers sent with a 304 response, as required by RFC 7232
+var notModifiedHeaders = []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Vary"}
+
+// notModified answers conditional requests with 304 Not Modified if the ETag of a cached
+// successful response matches. It only reads the metadata, so it is used for storages
+// reading it without the body, or for any ResponseMetaGetter if Options.ConditionalRequests is set.
+func notModified(ctx *gin.Context, storage CacheAdapter, cacheKey string, options *Options) bool {
+	ifNoneMatch := ctx.GetHeader("If-None-Match")
+	if ifNoneMatch == "" || (ctx.Request.Method != http.MethodGet && ctx.Request.Method != http.MethodHead) {
+		return false
+	}
+	getter, ok := storage.(ResponseMetaGetter)
+	if !ok {
+		return false
+	}
+	if options == nil || !options.ConditionalRequests {
+		if m, ok := storage.(interface{ readsMetaWithoutBody() bool }); !ok || !m.readsMetaWithoutBody() {
+			return false
+		}
+	}
+	meta, ok := getter.GetMeta(cacheKey)
+	if !ok || meta.Status < 200 || meta.Status > 299 || meta.ETag == "" || !etagMatches(ifNoneMatch, meta.ETag) {
+		return false
+	}
+	for _, k := range notModifiedHeaders {
+		for _, v := range meta.Headers.Values(k) {
+			ctx.Writer.Header().Add(k, v)
+		}
+	}
+	ctx.Writer.Header().Set(HeaderXCache, HeaderXCacheHit)
+	ctx.Status(http.StatusNotModified)
+	ctx.Writer.WriteHeaderNow()
+	return true
+}
+
+// etagMatches reports whether the If-None-Match header matches the ETag, using weak comparison.
+func etagMatches(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func sliceContainsInt(arr []int, ele int) bool {
 	for _, e := range arr {
 		if e == ele {
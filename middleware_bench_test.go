@@ -10,6 +10,7 @@ import (
 func BenchmarkMiddleware(b *testing.B) {
 	gin.SetMode(gin.TestMode)
 	c := NewCache()
+	defer c.Close()
 	r := gin.New()
 	r.Use(Middleware(c, nil))
 	r.GET("/ping", func(c *gin.Context) {
@@ -2,6 +2,7 @@ package ginche
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"net/http"
 	"net/http/httptest"
@@ -53,6 +54,10 @@ func (s *MiddlewareSuite) AfterTest() {
 	s.store.FlushAll()
 }
 
+func (s *MiddlewareSuite) TearDownTest() {
+	s.NoError(s.store.Close())
+}
+
 func (s *MiddlewareSuite) TestGetFromCache() {
 	// First request should add result to cache
 	w := httptest.NewRecorder()
@@ -137,6 +142,119 @@ func (s *MiddlewareSuite) TestSkipAllCachesWithKeyGeneratorSkipValue() {
 	s.Equal(HeaderXCacheSkip, w.Header().Get(HeaderXCache))
 }
 
+func (s *MiddlewareSuite) TestBrokenEntriesAreMisses() {
+	for _, broken := range []interface{}{
+		"not a response",
+		map[string]interface{}{"Status": 200, "Data": 42},
+		map[string]interface{}{"Status": "200", "Data": "body"},
+	} {
+		s.store.Set(String("/testGET"), broken)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/test", nil)
+		s.httpServer.ServeHTTP(w, req)
+
+		s.Equal(HeaderXCacheMiss, w.Header().Get(HeaderXCache))
+		s.Equal(`{"message":"test get"}`, w.Body.String())
+		d, ok := s.store.Get("/testGET")
+		s.True(ok)
+		s.IsType(&httpCacheItem{}, d, "the broken entry is replaced")
+	}
+}
+
+// metaStore is an in-memory cache reading response metadata like RedisAdapter.
+type metaStore struct {
+	CacheAdapter
+	metaReads int
+}
+
+func (m *metaStore) GetMeta(key string) (ResponseMeta, bool) {
+	m.metaReads++
+	value, ok := m.Get(key)
+	if !ok {
+		return ResponseMeta{}, false
+	}
+	return responseMetaOf(value)
+}
+
+func (s *MiddlewareSuite) conditionalServer(store CacheAdapter, options *Options) *gin.Engine {
+	r := gin.New()
+	r.Use(Middleware(store, options))
+	r.GET("/ok", func(c *gin.Context) {
+		c.Header("ETag", `"v1"`)
+		c.String(http.StatusOK, "ok")
+	})
+	r.GET("/missing", func(c *gin.Context) {
+		c.Header("ETag", `"v1"`)
+		c.String(http.StatusNotFound, "missing")
+	})
+	return r
+}
+
+func (s *MiddlewareSuite) conditionalRequest(r *gin.Engine, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", path, nil)
+	req.Header.Set("If-None-Match", `"v1"`)
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func (s *MiddlewareSuite) TestConditionalRequestsNeedOptIn() {
+	store := &metaStore{CacheAdapter: NewCache()}
+	defer store.Close()
+	r := s.conditionalServer(store, nil)
+	s.conditionalRequest(r, "/ok")
+
+	w := s.conditionalRequest(r, "/ok")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("ok", w.Body.String())
+	s.Equal(HeaderXCacheHit, w.Header().Get(HeaderXCache))
+	s.Zero(store.metaReads)
+}
+
+func (s *MiddlewareSuite) TestConditionalRequestsOption() {
+	store := &metaStore{CacheAdapter: NewCache()}
+	defer store.Close()
+	r := s.conditionalServer(store, &Options{ConditionalRequests: true})
+	s.conditionalRequest(r, "/ok")
+
+	w := s.conditionalRequest(r, "/ok")
+	s.Equal(http.StatusNotModified, w.Code)
+	s.Empty(w.Body.String())
+	s.Equal(`"v1"`, w.Header().Get("ETag"))
+	s.Equal(HeaderXCacheHit, w.Header().Get(HeaderXCache))
+}
+
+func (s *MiddlewareSuite) TestConditionalRequestsSkipErrors() {
+	store := &metaStore{CacheAdapter: NewCache()}
+	defer store.Close()
+	r := s.conditionalServer(store, &Options{ConditionalRequests: true})
+	s.conditionalRequest(r, "/missing")
+
+	w := s.conditionalRequest(r, "/missing")
+	s.Equal(http.StatusNotFound, w.Code)
+	s.Equal("missing", w.Body.String())
+	s.Equal(HeaderXCacheHit, w.Header().Get(HeaderXCache))
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch string
+		etag        string
+		match       bool
+	}{
+		{`"v1"`, `"v1"`, true},
+		{`"v0", "v1"`, `"v1"`, true},
+		{`W/"v1"`, `"v1"`, true},
+		{`"v1"`, `W/"v1"`, true},
+		{`*`, `"v1"`, true},
+		{`"v0"`, `"v1"`, false},
+		{`"v10"`, `"v1"`, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.match, etagMatches(test.ifNoneMatch, test.etag), test.ifNoneMatch)
+	}
+}
+
 func TestMiddleware(t *testing.T) {
 	suite.Run(t, new(MiddlewareSuite))
 }
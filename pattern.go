@@ -0,0 +1,154 @@
+package ginche
+
+import "strings"
+
+// Patterns used by Find and Scan are globs with the same syntax in every adapter,
+// which is the syntax of Redis KEYS and SCAN:
+//
+//	*       matches any sequence of characters, including none
+//	?       matches exactly one character
+//	[abc]   matches one of the characters, [^abc] any other character
+//	[a-z]   matches one character in the range
+//	\x      matches x literally
+//
+// Characters are bytes, so "?" matches a single byte of a multi-byte character.
+
+// globKind tells how a pattern can be matched.
+type globKind uint8
+
+const (
+	// globExact patterns contain no special characters and match a single key.
+	globExact globKind = iota
+	// globPrefix patterns are a literal followed by a single trailing "*".
+	globPrefix
+	// globGeneric patterns need the full glob matcher.
+	globGeneric
+)
+
+// globPattern is a pattern classified for the fastest way to match it.
+type globPattern struct {
+	pattern string
+	literal string
+	kind    globKind
+}
+
+func compileGlob(pattern string) globPattern {
+	special := strings.IndexAny(pattern, `*?[\`)
+	switch {
+	case special == -1:
+		return globPattern{pattern: pattern, literal: pattern, kind: globExact}
+	case special == len(pattern)-1 && pattern[special] == '*':
+		return globPattern{pattern: pattern, literal: pattern[:special], kind: globPrefix}
+	}
+	return globPattern{pattern: pattern, kind: globGeneric}
+}
+
+func (g globPattern) match(key string) bool {
+	switch g.kind {
+	case globExact:
+		return key == g.literal
+	case globPrefix:
+		return strings.HasPrefix(key, g.literal)
+	}
+	return matchGlob(g.pattern, key)
+}
+
+// matchGlob reports whether s matches the glob pattern.
+// It backtracks only to the last "*", so it runs in O(len(pattern) * len(s)).
+func matchGlob(pattern, s string) bool {
+	px, sx := 0, 0
+	// Position to restart from when a "*" has to absorb one more character
+	starPx, starSx := -1, 0
+	for px < len(pattern) || sx < len(s) {
+		if px < len(pattern) {
+			switch c := pattern[px]; c {
+			case '*':
+				starPx, starSx = px, sx+1
+				px++
+				continue
+			case '?':
+				if sx < len(s) {
+					px++
+					sx++
+					continue
+				}
+			case '[':
+				if sx < len(s) {
+					if ok, n := matchClass(pattern[px:], s[sx]); ok {
+						px += n
+						sx++
+						continue
+					}
+				}
+			case '\\':
+				if px+1 < len(pattern) {
+					px++
+					c = pattern[px]
+				}
+				if sx < len(s) && s[sx] == c {
+					px++
+					sx++
+					continue
+				}
+			default:
+				if sx < len(s) && s[sx] == c {
+					px++
+					sx++
+					continue
+				}
+			}
+		}
+		if starPx >= 0 && starSx <= len(s) {
+			px, sx = starPx, starSx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// matchClass matches c against the character class at the start of pattern
+// and returns the length of the class including the brackets.
+// An unterminated class extends to the end of the pattern, like in Redis.
+func matchClass(pattern string, c byte) (bool, int) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+	match := false
+	for i < len(pattern) && pattern[i] != ']' {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			match = match || pattern[i+1] == c
+			i += 2
+		case i+2 < len(pattern) && pattern[i+1] == '-':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			match = match || (c >= lo && c <= hi)
+			i += 3
+		default:
+			match = match || pattern[i] == c
+			i++
+		}
+	}
+	if i < len(pattern) {
+		i++
+	}
+	return match != negate, i
+}
+
+// escapeGlob escapes glob special characters, so the string only matches itself.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
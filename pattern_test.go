@@ -0,0 +1,76 @@
+package ginche
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "/users/1", true},
+		{"/users/*", "/users/1", true},
+		{"/users/*", "/posts/1", false},
+		{"*/1", "/users/1", true},
+		{"*/1", "/users/10", false},
+		{"/u*s/*1", "/users/21", true},
+		{"a*b*c", "abbbcbc", true},
+		{"a*b*c", "abbbcb", false},
+		{"?", "a", true},
+		{"?", "", false},
+		{"/users/?", "/users/12", false},
+		{"[abc]", "b", true},
+		{"[abc]", "d", false},
+		{"[^abc]", "d", true},
+		{"[^abc]", "a", false},
+		{"[a-c]x", "bx", true},
+		{"[c-a]x", "bx", true},
+		{"[a-c]x", "dx", false},
+		{`[\]]`, "]", true},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{`a\`, `a\`, true},
+		{"[ab", "b", true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+		if got := compileGlob(tt.pattern).match(tt.key); got != tt.want {
+			t.Errorf("compileGlob(%q).match(%q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		kind    globKind
+		literal string
+	}{
+		{"/users/1", globExact, "/users/1"},
+		{"/users/*", globPrefix, "/users/"},
+		{"*", globPrefix, ""},
+		{"/users/*/posts", globGeneric, ""},
+		{`/users/\*`, globGeneric, ""},
+		{"/users/?", globGeneric, ""},
+	}
+	for _, tt := range tests {
+		g := compileGlob(tt.pattern)
+		if g.kind != tt.kind || g.literal != tt.literal {
+			t.Errorf("compileGlob(%q) = %v, %q, want %v, %q", tt.pattern, g.kind, g.literal, tt.kind, tt.literal)
+		}
+	}
+}
+
+func TestEscapeGlob(t *testing.T) {
+	if got := escapeGlob(`a*b?c[d]\`); got != `a\*b\?c\[d\]\\` {
+		t.Errorf("escapeGlob() = %v", got)
+	}
+	if !matchGlob(escapeGlob(`a*b?c[d]\`), `a*b?c[d]\`) {
+		t.Errorf("escaped pattern does not match itself")
+	}
+}
@@ -0,0 +1,140 @@
+package ginche
+
+// EvictionPolicy is the algorithm InMemoryCache uses to choose which items
+// to evict when MaxEntries or MaxBytes is reached.
+type EvictionPolicy int
+
+const (
+	// EvictionLRU evicts the least recently used item.
+	EvictionLRU EvictionPolicy = iota
+	// EvictionTinyLFU uses W-TinyLFU: new items enter a small LRU window
+	// and are only admitted to the main cache if they are estimated to be
+	// accessed more frequently than the item they would replace.
+	// It protects hot items from being evicted by one-hit wonders.
+	// Without MaxEntries or MaxBytes nothing is evicted, and EvictionLRU is used instead.
+	EvictionTinyLFU
+)
+
+// segment is the part of the cache an item belongs to in the eviction policy.
+type segment uint8
+
+const (
+	segmentWindow segment = iota
+	segmentProbation
+	segmentProtected
+)
+
+// evictionPolicy tracks items of InMemoryCache and picks eviction victims.
+// All methods are called with the cache lock held.
+type evictionPolicy interface {
+	// add starts tracking a new item.
+	add(item *Item)
+	// access records a hit on an already tracked item.
+	access(item *Item)
+	// remove stops tracking an item.
+	remove(item *Item)
+	// victim returns the item that should be evicted next.
+	victim() *Item
+	// record registers a lookup of the key, whether it is cached or not.
+	record(key string)
+	// reset stops tracking all items.
+	reset()
+}
+
+// newEvictionPolicy returns the policy of one of the shards of a cache configured by conf.
+// TinyLFU only matters once items are evicted and sizes its sketch from the limits,
+// so caches without MaxEntries or MaxBytes use LRU.
+func newEvictionPolicy(conf CacheConfig, shards int) evictionPolicy {
+	if conf.EvictionPolicy == EvictionTinyLFU {
+		if width := tinyLFUWidth(conf, shards); width > 0 {
+			return newTinyLFUPolicy(width)
+		}
+	}
+	return newLRUPolicy()
+}
+
+// lruPolicy evicts the least recently used item.
+type lruPolicy struct {
+	ll itemList
+}
+
+func newLRUPolicy() *lruPolicy {
+	p := &lruPolicy{}
+	p.ll.init()
+	return p
+}
+
+func (p *lruPolicy) add(item *Item) {
+	p.ll.pushFront(item)
+}
+
+func (p *lruPolicy) access(item *Item) {
+	p.ll.moveToFront(item)
+}
+
+func (p *lruPolicy) remove(item *Item) {
+	p.ll.remove(item)
+}
+
+func (p *lruPolicy) victim() *Item {
+	return p.ll.back()
+}
+
+func (p *lruPolicy) record(string) {}
+
+func (p *lruPolicy) reset() {
+	p.ll.init()
+}
+
+// itemList is an intrusive doubly linked list of items.
+// Items link to each other directly, so tracking an item does not allocate.
+type itemList struct {
+	root Item
+	len  int
+}
+
+func (l *itemList) init() {
+	l.root.next = &l.root
+	l.root.prev = &l.root
+	l.len = 0
+}
+
+func (l *itemList) pushFront(item *Item) {
+	item.prev = &l.root
+	item.next = l.root.next
+	l.root.next.prev = item
+	l.root.next = item
+	l.len++
+}
+
+func (l *itemList) remove(item *Item) {
+	item.prev.next = item.next
+	item.next.prev = item.prev
+	item.prev = nil
+	item.next = nil
+	l.len--
+}
+
+func (l *itemList) moveToFront(item *Item) {
+	if l.root.next == item {
+		return
+	}
+	l.remove(item)
+	l.pushFront(item)
+}
+
+// front returns the first item of the list or nil if it is empty.
+func (l *itemList) front() *Item {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.next
+}
+
+// back returns the last item of the list or nil if it is empty.
+func (l *itemList) back() *Item {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.prev
+}
@@ -4,39 +4,108 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/redis/go-redis/v9"
-	"log"
 	"strings"
+	"sync"
 	"time"
 )
 
+// redisScanCount is the number of keys requested from Redis per SCAN call
+const redisScanCount = 1000
+
+var (
+	// redisSetScript sets the key with an optional TTL in milliseconds and publishes the invalidation
+	redisSetScript = redis.NewScript(`
+if tonumber(ARGV[2]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+redis.call("PUBLISH", ARGV[3], ARGV[4])
+return 1`)
+	// redisDeleteScript deletes the key and publishes the invalidation
+	redisDeleteScript = redis.NewScript(`
+redis.call("DEL", KEYS[1])
+redis.call("PUBLISH", ARGV[1], ARGV[2])
+return 1`)
+)
+
+// RedisAdapter stores items in Redis and keeps recently read items in a local in-memory cache.
+// If CacheConfig.Namespace is set, all keys are stored as "<namespace>:<key>",
+// so several caches can share the same database.
+// Instances keep their local caches coherent through an InvalidationBus,
+// by default a RedisInvalidationBus on the same client.
 type RedisAdapter struct {
-	conn          *redis.Client
+	conn          redis.UniversalClient
+	ownsClient    bool
 	inMemoryCache *InMemoryCache
-	pubsub        *redis.PubSub
 	config        *CacheConfig
+	prefix        string
+	codec         *valueCodec
+	id            string
+	bus           InvalidationBus
+	ownsBus       bool
+	unsubscribe   func()
+	closeOnce     sync.Once
+	closeErr      error
+	// localMu orders local cache fills against invalidations, see setLocal
+	localMu    sync.RWMutex
+	generation uint64
 }
 
+// NewRedisAdapter creates a Redis client from the options and an adapter using it.
+// The client is closed with the adapter.
 func NewRedisAdapter(redisConfig *redis.Options, config ...CacheConfig) (CacheAdapter, error) {
-	redisClient := redis.NewClient(redisConfig)
-	pubsub := redisClient.Subscribe(context.Background(), "cache_updates:*")
+	return newRedisAdapter(redis.NewClient(redisConfig), true, config...)
+}
+
+// NewRedisAdapterWithClient creates an adapter using an existing client,
+// e.g. a *redis.ClusterClient, a Sentinel failover client or a client shared with the application.
+// In cluster mode Find and FlushAll visit every master, and Scan returns ErrScanNotSupported.
+// The client is not closed with the adapter.
+func NewRedisAdapterWithClient(client redis.UniversalClient, config ...CacheConfig) (CacheAdapter, error) {
+	return newRedisAdapter(client, false, config...)
+}
+
+func newRedisAdapter(redisClient redis.UniversalClient, ownsClient bool, config ...CacheConfig) (CacheAdapter, error) {
 	var conf CacheConfig
 	if config != nil {
 		conf = config[0]
-	} else {
+	}
+	if conf.TTL == nil {
 		ttl := time.Minute * 5
-		conf = CacheConfig{
-			TTL:             &ttl,
-			CleanupInterval: nil,
-		}
+		conf.TTL = &ttl
+	}
+	var prefix string
+	if conf.Namespace != "" {
+		prefix = conf.Namespace + ":"
 	}
-	inMemory := NewInMemoryCache(conf)
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	// The local cache only holds copies of Redis items, they are not worth a snapshot
+	local := conf
+	local.SnapshotFile = ""
+	inMemory := NewInMemoryCache(local)
 	cache := &RedisAdapter{
 		conn:          redisClient,
+		ownsClient:    ownsClient,
 		inMemoryCache: inMemory.(*InMemoryCache),
-		pubsub:        pubsub,
 		config:        &conf,
+		prefix:        prefix,
+		codec:         newValueCodec(conf),
+		id:            id,
+		bus:           conf.InvalidationBus,
+	}
+	if cache.bus == nil {
+		cache.bus = NewRedisInvalidationBus(redisClient, nil)
+		cache.ownsBus = true
+	}
+	cache.unsubscribe = cache.bus.Subscribe(cache.handleInvalidation)
+	if err := pingWithTimeout(cache, conf.PingTimeout); err != nil {
+		cache.Close()
+		return nil, err
 	}
-	go cache.handleUpdates()
 	return cache, nil
 }
 
@@ -50,56 +119,547 @@ func (r *RedisAdapter) Set(key *string, value interface{}, config ...*ItemConfig
 		ttl = config[0].TTL
 	}
 
-	val, _ := json.Marshal(item{Data: value})
+	ctx := context.Background()
+	event := r.keysEvent([]string{*key})
+	if fields, ok := r.hashFields(*key, value); ok {
+		channel, payload, inPipeline := r.busPayload(event)
+		_, _ = r.conn.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.setHash(ctx, pipe, *key, fields, *ttl)
+			if inPipeline {
+				pipe.Publish(ctx, channel, payload)
+			}
+			return nil
+		})
+		if !inPipeline {
+			_ = r.bus.Publish(ctx, event)
+		}
+		r.dropLocal(*key)
+		return
+	}
 
-	r.conn.Set(context.Background(), *key, string(val), *ttl)
-	r.conn.Publish(context.Background(), "cache_updates:"+*key, "1")
+	val, err := r.encode(*key, value)
+	if err != nil {
+		return
+	}
+	if channel, payload, ok := r.busPayload(event); ok {
+		// Writes and announces the value atomically in a single round trip
+		redisSetScript.Run(ctx, r.conn, []string{r.prefix + *key}, string(val), redisMilliseconds(*ttl), channel, payload)
+	} else {
+		r.conn.Set(ctx, r.prefix+*key, string(val), *ttl)
+		_ = r.bus.Publish(ctx, event)
+	}
+	r.dropLocal(*key)
 }
 
 func (r *RedisAdapter) Get(key string) (interface{}, bool) {
-	if val, ok := r.inMemoryCache.Get(key); ok {
-		return val, true
+	value, _, ok := r.GetWithTTL(key)
+	return value, ok
+}
+
+// GetWithTTL works like Get and also returns the remaining TTL of the item.
+func (r *RedisAdapter) GetWithTTL(key string) (interface{}, time.Duration, bool) {
+	if val, ttl, ok := r.inMemoryCache.GetWithTTL(key); ok {
+		return val, ttl, true
 	}
-	value, err := r.conn.Get(context.Background(), key).Result()
-	if err != nil {
+	generation := r.localGeneration()
+	// Value and TTL are fetched in a single round trip
+	ctx := context.Background()
+	var get *redis.StringCmd
+	var hash *redis.MapStringStringCmd
+	var pttl *redis.DurationCmd
+	_, _ = r.conn.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, r.prefix+key)
+		if r.config.HashResponses {
+			hash = pipe.HGetAll(ctx, r.prefix+key)
+		}
+		pttl = pipe.PTTL(ctx, r.prefix+key)
+		return nil
+	})
+	var value *string
+	if v, err := get.Result(); err == nil {
+		value = &v
+	}
+	data, ok := r.decodeReply(key, value, hash)
+	if !ok {
+		return nil, 0, false
+	}
+	ttl := r.setLocal(key, data, pttl.Val(), generation)
+
+	return data, ttl, true
+}
+
+// hashFields returns the hash fields of the value if HashResponses is enabled and it is a cached response.
+func (r *RedisAdapter) hashFields(key string, value interface{}) (map[string]interface{}, bool) {
+	if !r.config.HashResponses {
 		return nil, false
 	}
-	var data item
-	err = json.Unmarshal([]byte(value), &data)
-	if err != nil {
+	fields, ok := responseHash(value)
+	if !ok {
 		return nil, false
 	}
-	ttl := r.conn.TTL(context.Background(), key).Val()
-	r.inMemoryCache.Set(&key, data.Data, &ItemConfig{TTL: &ttl})
+	if body, compressed := r.codec.encode([]byte(fields[redisFieldBody].(string))); compressed {
+		fields[redisFieldBody] = string(body)
+		fields[redisFieldCompressed] = "1"
+	}
+	if r.codec.verify {
+		fields[redisFieldBodySum] = string(r.codec.sum(r.prefix+key, []byte(fields[redisFieldBody].(string))))
+		fields[redisFieldChecksum] = string(r.hashChecksum(key, func(name string) string {
+			v, _ := fields[name].(string)
+			return v
+		}))
+	}
+	return fields, true
+}
 
-	return data.Data, true
+// encode serializes the value, compresses it if Compression is enabled
+// and adds its integrity check if VerifyIntegrity is enabled.
+func (r *RedisAdapter) encode(key string, value interface{}) ([]byte, error) {
+	val, err := json.Marshal(item{Data: value})
+	if err != nil {
+		return nil, err
+	}
+	return r.codec.seal(r.prefix+key, val), nil
+}
+
+// CompressionStats reports how well the values written by the adapter compress.
+func (r *RedisAdapter) CompressionStats() CompressionStats {
+	return r.codec.stats()
+}
+
+// setLocal stores a value read from Redis in the local cache for its remaining TTL and returns the TTL.
+// Keys without an expiry report a negative TTL, they are kept for the default TTL locally
+// and reported with a zero TTL.
+// The value is only stored if the local cache was not invalidated since generation was read,
+// before the value was fetched, otherwise a value deleted or replaced meanwhile would be kept.
+func (r *RedisAdapter) setLocal(key string, value interface{}, ttl time.Duration, generation uint64) time.Duration {
+	if ttl < 0 {
+		ttl = 0
+	}
+	r.localMu.RLock()
+	defer r.localMu.RUnlock()
+	if r.generation != generation {
+		return ttl
+	}
+	if ttl > 0 {
+		r.inMemoryCache.Set(&key, value, &ItemConfig{TTL: &ttl})
+	} else {
+		r.inMemoryCache.Set(&key, value)
+	}
+	return ttl
+}
+
+// localGeneration returns the number of local cache invalidations so far.
+func (r *RedisAdapter) localGeneration() uint64 {
+	r.localMu.RLock()
+	defer r.localMu.RUnlock()
+	return r.generation
+}
+
+// dropLocal deletes the keys from the local cache and starts a new generation,
+// so reads that fetched the keys before are not stored locally.
+func (r *RedisAdapter) dropLocal(keys ...string) {
+	r.localMu.Lock()
+	defer r.localMu.Unlock()
+	r.generation++
+	r.inMemoryCache.DeleteMulti(keys)
+}
+
+// flushLocal clears the local cache and starts a new generation like dropLocal.
+func (r *RedisAdapter) flushLocal() {
+	r.localMu.Lock()
+	defer r.localMu.Unlock()
+	r.generation++
+	r.inMemoryCache.FlushAll()
 }
 
 func (r *RedisAdapter) Delete(key string) {
-	r.conn.Del(context.Background(), key)
-	r.inMemoryCache.Delete(key)
-	r.conn.Publish(context.Background(), "cache_updates:"+key, "1")
+	ctx := context.Background()
+	event := r.keysEvent([]string{key})
+	if channel, payload, ok := r.busPayload(event); ok {
+		redisDeleteScript.Run(ctx, r.conn, []string{r.prefix + key}, channel, payload)
+	} else {
+		r.conn.Del(ctx, r.prefix+key)
+		_ = r.bus.Publish(ctx, event)
+	}
+	r.dropLocal(key)
 }
 
-func (r *RedisAdapter) Find(pattern string) []string {
-	keys := make([]string, 0)
-	keys, _ = r.conn.Keys(context.Background(), pattern).Result()
+// GetMulti returns the values of the keys found in the local cache or in Redis.
+func (r *RedisAdapter) GetMulti(keys []string) map[string]interface{} {
+	entries := r.GetMultiWithTTL(keys)
+	values := make(map[string]interface{}, len(entries))
+	for key, entry := range entries {
+		values[key] = entry.Value
+	}
+	return values
+}
 
-	return keys
+// GetMultiWithTTL works like GetMulti and also returns the remaining TTL of the items.
+// Keys missing from the local cache are fetched in a single round trip,
+// with MGET or, if keys are spread over several nodes, a pipeline of GETs.
+func (r *RedisAdapter) GetMultiWithTTL(keys []string) map[string]Entry {
+	entries := r.inMemoryCache.GetMultiWithTTL(keys)
+	misses := make([]string, 0, len(keys)-len(entries))
+	for _, key := range keys {
+		if _, ok := entries[key]; !ok {
+			misses = append(misses, key)
+		}
+	}
+	if len(misses) == 0 {
+		return entries
+	}
+
+	generation := r.localGeneration()
+	ctx := context.Background()
+	values := make([]*redis.StringCmd, len(misses))
+	hashes := make([]*redis.MapStringStringCmd, len(misses))
+	ttls := make([]*redis.DurationCmd, len(misses))
+	var mget *redis.SliceCmd
+	_, _ = r.conn.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if !r.multiNode() {
+			prefixed := make([]string, len(misses))
+			for i, key := range misses {
+				prefixed[i] = r.prefix + key
+			}
+			mget = pipe.MGet(ctx, prefixed...)
+		}
+		for i, key := range misses {
+			if mget == nil {
+				values[i] = pipe.Get(ctx, r.prefix+key)
+			}
+			if r.config.HashResponses {
+				hashes[i] = pipe.HGetAll(ctx, r.prefix+key)
+			}
+			ttls[i] = pipe.PTTL(ctx, r.prefix+key)
+		}
+		return nil
+	})
+
+	for i, key := range misses {
+		// MGET returns nil for keys holding hashes
+		var value *string
+		if mget != nil {
+			if i < len(mget.Val()) {
+				if v, ok := mget.Val()[i].(string); ok {
+					value = &v
+				}
+			}
+		} else if v, err := values[i].Result(); err == nil {
+			value = &v
+		}
+		data, ok := r.decodeReply(key, value, hashes[i])
+		if !ok {
+			continue
+		}
+		entries[key] = Entry{Value: data, TTL: r.setLocal(key, data, ttls[i].Val(), generation)}
+	}
+	return entries
 }
 
-func (r *RedisAdapter) handleUpdates() {
-	for {
-		msg, err := r.pubsub.ReceiveMessage(context.Background())
+// SetMulti stores the items in a single pipeline and announces them in a single invalidation event.
+func (r *RedisAdapter) SetMulti(items map[string]interface{}, config ...*ItemConfig) {
+	ttl := r.config.TTL
+	if config != nil && config[0].TTL != nil {
+		ttl = config[0].TTL
+	}
+	ctx := context.Background()
+	keys := make([]string, 0, len(items))
+	values := make([]interface{}, 0, len(items))
+	for key, value := range items {
+		if fields, ok := r.hashFields(key, value); ok {
+			keys = append(keys, key)
+			values = append(values, fields)
+			continue
+		}
+		val, err := r.encode(key, value)
 		if err != nil {
-			log.Printf("Error receiving pub/sub message: %v", err)
 			continue
 		}
-		key := strings.TrimPrefix(msg.Channel, "cache_updates:")
-		r.inMemoryCache.Delete(key)
+		keys = append(keys, key)
+		values = append(values, string(val))
+	}
+	if len(keys) == 0 {
+		return
+	}
+	event := r.keysEvent(keys)
+	channel, payload, inPipeline := r.busPayload(event)
+	_, _ = r.conn.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			if fields, ok := values[i].(map[string]interface{}); ok {
+				r.setHash(ctx, pipe, key, fields, *ttl)
+			} else {
+				pipe.Set(ctx, r.prefix+key, values[i], *ttl)
+			}
+		}
+		if inPipeline {
+			pipe.Publish(ctx, channel, payload)
+		}
+		return nil
+	})
+	if !inPipeline {
+		_ = r.bus.Publish(ctx, event)
+	}
+	r.dropLocal(keys...)
+}
+
+// DeleteMulti deletes the keys with a single DEL or, if keys are spread over several nodes, a pipeline.
+func (r *RedisAdapter) DeleteMulti(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	ctx := context.Background()
+	event := r.keysEvent(keys)
+	channel, payload, inPipeline := r.busPayload(event)
+	_, _ = r.conn.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if r.multiNode() {
+			for _, key := range keys {
+				pipe.Del(ctx, r.prefix+key)
+			}
+		} else {
+			prefixed := make([]string, len(keys))
+			for i, key := range keys {
+				prefixed[i] = r.prefix + key
+			}
+			pipe.Del(ctx, prefixed...)
+		}
+		if inPipeline {
+			pipe.Publish(ctx, channel, payload)
+		}
+		return nil
+	})
+	if !inPipeline {
+		_ = r.bus.Publish(ctx, event)
 	}
+	r.dropLocal(keys...)
 }
 
+// Find returns all keys matching the glob pattern.
+// It walks the keyspace with SCAN instead of KEYS, so Redis is never blocked.
+// In cluster mode every master is scanned.
+func (r *RedisAdapter) Find(pattern string) []string {
+	var mu sync.Mutex
+	var keys []string
+	seen := make(map[string]struct{})
+	_ = r.forEachNode(context.Background(), func(ctx context.Context, node redis.Cmdable) error {
+		iter := node.Scan(ctx, 0, escapeGlob(r.prefix)+pattern, redisScanCount).Iterator()
+		for iter.Next(ctx) {
+			key := strings.TrimPrefix(iter.Val(), r.prefix)
+			mu.Lock()
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+			mu.Unlock()
+		}
+		return iter.Err()
+	})
+	return keys
+}
+
+// Scan returns a batch of keys matching the glob pattern using Redis SCAN.
+// Like SCAN, it may return a key more than once.
+// Cursors can not span several nodes, so it returns ErrScanNotSupported in cluster mode.
+func (r *RedisAdapter) Scan(ctx context.Context, pattern string, cursor uint64, count int64) ([]string, uint64, error) {
+	if r.multiNode() {
+		return nil, 0, ErrScanNotSupported
+	}
+	keys, next, err := r.conn.Scan(ctx, cursor, escapeGlob(r.prefix)+pattern, count).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, r.prefix)
+	}
+	return keys, next, nil
+}
+
+// publish tells other instances to drop the key from their local caches.
+func (r *RedisAdapter) publish(kind InvalidationKind, key string) {
+	_ = r.bus.Publish(context.Background(), InvalidationEvent{
+		Kind:      kind,
+		Namespace: r.config.Namespace,
+		Key:       key,
+		Source:    r.id,
+	})
+}
+
+// keysEvent returns the event telling other instances to drop the keys from their local caches.
+func (r *RedisAdapter) keysEvent(keys []string) InvalidationEvent {
+	event := InvalidationEvent{
+		Kind:      InvalidateKey,
+		Namespace: r.config.Namespace,
+		Key:       keys[0],
+		Source:    r.id,
+	}
+	if len(keys) > 1 {
+		event.Keys = keys[1:]
+	}
+	return event
+}
+
+// busPayload returns the channel and payload of the event if it can be published
+// together with the write, which is the case for a RedisInvalidationBus on the same single-node client.
+// With several nodes the write and the subscription may live on different nodes,
+// so events are published separately.
+func (r *RedisAdapter) busPayload(event InvalidationEvent) (string, []byte, bool) {
+	bus, ok := r.bus.(*RedisInvalidationBus)
+	if !ok || bus.conn != r.conn || r.multiNode() {
+		return "", nil, false
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return "", nil, false
+	}
+	return bus.options.Channel, payload, true
+}
+
+// redisMilliseconds converts the TTL for SET PX, rounding TTLs below a millisecond up
+// like go-redis does. Zero means no expiry.
+func redisMilliseconds(ttl time.Duration) int64 {
+	if ttl > 0 && ttl < time.Millisecond {
+		return 1
+	}
+	return ttl.Milliseconds()
+}
+
+// handleInvalidation applies events of other instances sharing the namespace to the local cache.
+// Events may have been missed on a reset, so the whole local cache is dropped.
+func (r *RedisAdapter) handleInvalidation(event InvalidationEvent) {
+	if event.Kind == InvalidateReset {
+		r.flushLocal()
+		return
+	}
+	if event.Source == r.id || event.Namespace != r.config.Namespace {
+		return
+	}
+	switch event.Kind {
+	case InvalidateKey:
+		// Keys of the event may be shared with the publisher, so they are copied
+		keys := make([]string, 0, len(event.Keys)+1)
+		r.dropLocal(append(append(keys, event.Key), event.Keys...)...)
+	case InvalidateTag, InvalidateFlush:
+		r.flushLocal()
+	}
+}
+
+// Ping checks the connection to Redis, in cluster mode to every master.
+func (r *RedisAdapter) Ping(ctx context.Context) error {
+	return r.forEachNode(ctx, func(ctx context.Context, node redis.Cmdable) error {
+		return node.Ping(ctx).Err()
+	})
+}
+
+// InvalidationStatus returns the state of the invalidation bus.
+// Buses that do not track a connection are always reported as connected.
+func (r *RedisAdapter) InvalidationStatus() BusStatus {
+	if bus, ok := r.bus.(interface{ Status() BusStatus }); ok {
+		return bus.Status()
+	}
+	return BusStatus{Connected: true}
+}
+
+// FlushAll deletes all keys of the namespace from Redis, clears the local cache
+// and tells other instances sharing the namespace to clear theirs.
+// Keys are removed incrementally with SCAN and UNLINK, so Redis is never blocked.
+// Without a namespace the keys can not be told apart from other data in the database,
+// so Redis is left untouched and only the local caches are cleared.
+// In cluster mode every master is flushed.
 func (r *RedisAdapter) FlushAll() {
-	// No idea for now
+	if r.prefix == "" {
+		r.flushLocal()
+		r.publish(InvalidateFlush, "")
+		return
+	}
+	_ = r.forEachNode(context.Background(), func(ctx context.Context, node redis.Cmdable) error {
+		return r.unlinkMatching(ctx, node)
+	})
+	r.flushLocal()
+	r.publish(InvalidateFlush, "")
+}
+
+// unlinkMatching removes the keys of the namespace from a single node.
+// In cluster mode keys are unlinked one by one in a pipeline,
+// since a single UNLINK can not span hash slots.
+func (r *RedisAdapter) unlinkMatching(ctx context.Context, node redis.Cmdable) error {
+	iter := node.Scan(ctx, 0, escapeGlob(r.prefix)+"*", redisScanCount).Iterator()
+	keys := make([]string, 0, redisScanCount)
+	unlink := func() error {
+		var err error
+		if r.multiNode() {
+			_, err = node.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, key := range keys {
+					pipe.Unlink(ctx, key)
+				}
+				return nil
+			})
+		} else {
+			err = node.Unlink(ctx, keys...).Err()
+		}
+		keys = keys[:0]
+		return err
+	}
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == redisScanCount {
+			if err := unlink(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(keys) > 0 {
+		if err := unlink(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// forEachNode calls fn for every master of a cluster or shard of a ring concurrently,
+// and once with the client itself otherwise.
+func (r *RedisAdapter) forEachNode(ctx context.Context, fn func(ctx context.Context, node redis.Cmdable) error) error {
+	switch conn := r.conn.(type) {
+	case *redis.ClusterClient:
+		return conn.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return fn(ctx, node)
+		})
+	case *redis.Ring:
+		return conn.ForEachShard(ctx, func(ctx context.Context, node *redis.Client) error {
+			return fn(ctx, node)
+		})
+	default:
+		return fn(ctx, r.conn)
+	}
+}
+
+// multiNode reports whether keys are spread over several nodes.
+func (r *RedisAdapter) multiNode() bool {
+	switch r.conn.(type) {
+	case *redis.ClusterClient, *redis.Ring:
+		return true
+	}
+	return false
+}
+
+// Close unsubscribes from invalidations, closes the bus if the adapter created it,
+// closes the local cache and the Redis client unless it was passed to NewRedisAdapterWithClient.
+// Writes are not buffered, so there is nothing to flush.
+// It is safe to call Close multiple times.
+func (r *RedisAdapter) Close() error {
+	r.closeOnce.Do(func() {
+		r.unsubscribe()
+		var errs []error
+		if r.ownsBus {
+			errs = append(errs, r.bus.Close())
+		}
+		errs = append(errs, r.inMemoryCache.Close())
+		if r.ownsClient {
+			errs = append(errs, r.conn.Close())
+		}
+		for _, err := range errs {
+			if err != nil && r.closeErr == nil {
+				r.closeErr = err
+			}
+		}
+	})
+	return r.closeErr
 }
@@ -1,12 +1,15 @@
 package ginche
 
 import (
-	"github.com/alicebob/miniredis"
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/suite"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 )
@@ -47,10 +50,23 @@ func (s *RedisSuite) TestSetWithConfig() {
 }
 
 func (s *RedisSuite) TearDownTest() {
+	s.NoError(s.store.Close())
 	s.store = nil
 	s.redis.Close()
 }
 
+func (s *RedisSuite) TestClose() {
+	store, err := NewRedisAdapter(&redis.Options{
+		Addr: s.redis.Addr(),
+	})
+	s.NoError(err)
+	s.NoError(store.Close())
+	s.NoError(store.Close())
+
+	_, ok := store.Get("test_key")
+	s.False(ok)
+}
+
 func (s *RedisSuite) TestWithMiddleware() {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
@@ -69,6 +85,456 @@ func (s *RedisSuite) TestWithMiddleware() {
 	s.Equal(d.(map[string]interface{})["Data"], w.Body.String())
 }
 
+func (s *RedisSuite) TestFlushAllNamespace() {
+	first, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{Namespace: "first"})
+	defer first.Close()
+	replica, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{Namespace: "first"})
+	defer replica.Close()
+	second, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{Namespace: "second"})
+	defer second.Close()
+
+	for i := 0; i < 2500; i++ {
+		key := fmt.Sprintf("key%d", i)
+		first.Set(&key, i)
+	}
+	second.Set(String("key0"), "second")
+	s.redis.Set("unrelated", "data")
+	s.True(s.redis.Exists("first:key0"))
+	s.ElementsMatch([]string{"key0"}, second.Find("key*"))
+
+	// Populate the local cache of the replica
+	_, ok := replica.Get("key1")
+	s.True(ok)
+
+	first.FlushAll()
+	s.Empty(first.Find("*"))
+	_, ok = first.Get("key1")
+	s.False(ok)
+	s.Eventually(func() bool {
+		_, ok := replica.(*RedisAdapter).inMemoryCache.Get("key1")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	d, ok := second.Get("key0")
+	s.True(ok)
+	s.Equal("second", d)
+	s.True(s.redis.Exists("unrelated"))
+}
+
+func (s *RedisSuite) TestSetUnencodable() {
+	s.store.Set(String("key"), "value")
+	s.store.Set(String("key"), make(chan int))
+	stored, err := s.redis.Get("key")
+	s.NoError(err)
+	s.Equal(`{"Data":"value"}`, stored)
+	d, ok := s.store.Get("key")
+	s.True(ok)
+	s.Equal("value", d)
+}
+
+func (s *RedisSuite) TestStaleReadsDoNotFillLocalCache() {
+	store := s.store.(*RedisAdapter)
+	other, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()})
+	defer other.Close()
+
+	// A read fetched the value, then the key was deleted before the read stored it locally
+	generation := store.localGeneration()
+	store.Delete("key")
+	store.setLocal("key", "old", time.Minute, generation)
+	_, ok := store.inMemoryCache.Get("key")
+	s.False(ok)
+
+	// The same holds for invalidations of other instances
+	generation = store.localGeneration()
+	other.Delete("key")
+	s.Eventually(func() bool {
+		return store.localGeneration() != generation
+	}, time.Second, 10*time.Millisecond)
+	store.setLocal("key", "old", time.Minute, generation)
+	_, ok = store.inMemoryCache.Get("key")
+	s.False(ok)
+
+	// Reads without a concurrent invalidation are stored
+	store.setLocal("key", "value", time.Minute, store.localGeneration())
+	_, ok = store.inMemoryCache.Get("key")
+	s.True(ok)
+}
+
+func (s *RedisSuite) TestConcurrentGetAndDelete() {
+	for i := 0; i < 300; i++ {
+		s.store.Set(String("key"), "value")
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.store.Get("key")
+		}()
+		s.store.Delete("key")
+		wg.Wait()
+		_, ok := s.store.Get("key")
+		s.Require().False(ok, "run %d served a deleted key", i)
+	}
+}
+
+func (s *RedisSuite) TestFlushAllWithoutNamespace() {
+	s.redis.Set("app:session:1", "data")
+	s.store.Set(String("key"), "value")
+	s.store.FlushAll()
+	s.True(s.redis.Exists("app:session:1"))
+	s.True(s.redis.Exists("key"))
+	_, ok := s.store.(*RedisAdapter).inMemoryCache.Get("key")
+	s.False(ok)
+}
+
+func (s *RedisSuite) TestFindAndScan() {
+	store, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{Namespace: "ns"})
+	defer store.Close()
+	memory := NewInMemoryCache()
+	defer memory.Close()
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("/users/%d", i)
+		store.Set(&key, i)
+		memory.Set(&key, i)
+	}
+	store.Set(String("/posts/1"), 1)
+	memory.Set(String("/posts/1"), 1)
+
+	// Patterns mean the same in every adapter
+	for _, pattern := range []string{"/users/1", "/users/1*", "/users/?", "*/1", "/[pu]*/[0-2]", "/comments/*"} {
+		s.ElementsMatch(memory.Find(pattern), store.Find(pattern), pattern)
+	}
+
+	var keys []string
+	var cursor uint64
+	for {
+		batch, next, err := store.(Scanner).Scan(context.Background(), "/users/*", cursor, 10)
+		s.NoError(err)
+		keys = append(keys, batch...)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	s.ElementsMatch(memory.Find("/users/*"), keys)
+}
+
+func (s *RedisSuite) TestInvalidatesOtherInstances() {
+	first, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()})
+	defer first.Close()
+	second, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()})
+	defer second.Close()
+
+	first.Set(String("key"), "old")
+	// Populate the local cache of the second instance
+	d, ok := second.Get("key")
+	s.True(ok)
+	s.Equal("old", d)
+
+	first.Set(String("key"), "new")
+	s.Eventually(func() bool {
+		d, ok := second.Get("key")
+		return ok && d == "new"
+	}, time.Second, 10*time.Millisecond)
+
+	first.Delete("key")
+	s.Eventually(func() bool {
+		_, ok := second.Get("key")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func (s *RedisSuite) TestSharedInvalidationBus() {
+	bus := NewInProcessBus()
+	var events []InvalidationEvent
+	unsubscribe := bus.Subscribe(func(event InvalidationEvent) {
+		events = append(events, event)
+	})
+	defer unsubscribe()
+	first, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{Namespace: "ns", InvalidationBus: bus})
+	defer first.Close()
+	second, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{Namespace: "ns", InvalidationBus: bus})
+	defer second.Close()
+	other, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{Namespace: "other", InvalidationBus: bus})
+	defer other.Close()
+
+	first.Set(String("key"), "value")
+	second.Get("key")
+	other.Set(String("key"), "other")
+	other.Get("key")
+
+	first.Set(String("key"), "new")
+	d, ok := first.Get("key")
+	s.True(ok)
+	s.Equal("new", d)
+	_, ok = second.(*RedisAdapter).inMemoryCache.Get("key")
+	s.False(ok)
+	// Own echoes are skipped, so the local copy read after the write survives
+	s.Equal(InvalidationEvent{Kind: InvalidateKey, Namespace: "ns", Key: "key", Source: first.(*RedisAdapter).id}, events[len(events)-1])
+	first.(*RedisAdapter).handleInvalidation(events[len(events)-1])
+	_, ok = first.(*RedisAdapter).inMemoryCache.Get("key")
+	s.True(ok)
+	// Other namespaces are not affected
+	_, ok = other.(*RedisAdapter).inMemoryCache.Get("key")
+	s.True(ok)
+
+	second.FlushAll()
+	_, ok = first.(*RedisAdapter).inMemoryCache.Get("key")
+	s.False(ok)
+	s.Equal(InvalidationEvent{Kind: InvalidateFlush, Namespace: "ns", Source: second.(*RedisAdapter).id}, events[len(events)-1])
+
+	// A shared bus outlives the adapters
+	s.NoError(first.Close())
+	s.NoError(bus.Publish(context.Background(), InvalidationEvent{Kind: InvalidateFlush, Namespace: "other"}))
+	_, ok = other.(*RedisAdapter).inMemoryCache.Get("key")
+	s.False(ok)
+}
+
+func (s *RedisSuite) TestResetOnReconnect() {
+	bus := NewInProcessBus()
+	store, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{Namespace: "ns", InvalidationBus: bus})
+	defer store.Close()
+	s.Equal(BusStatus{Connected: true}, store.(*RedisAdapter).InvalidationStatus())
+	store.Set(String("key"), "value")
+	store.Get("key")
+
+	s.NoError(bus.Publish(context.Background(), InvalidationEvent{Kind: InvalidateReset}))
+	_, ok := store.(*RedisAdapter).inMemoryCache.Get("key")
+	s.False(ok)
+}
+
+func (s *RedisSuite) TestInvalidationStatus() {
+	s.Eventually(func() bool {
+		return s.store.(*RedisAdapter).InvalidationStatus().Connected
+	}, time.Second, 10*time.Millisecond)
+}
+
+func (s *RedisSuite) TestWithClient() {
+	client := redis.NewClient(&redis.Options{Addr: s.redis.Addr()})
+	defer client.Close()
+	store, err := NewRedisAdapterWithClient(client, CacheConfig{Namespace: "ns"})
+	s.NoError(err)
+	store.Set(String("key"), "value")
+	s.True(s.redis.Exists("ns:key"))
+	s.NoError(store.Close())
+	// The shared client stays open
+	s.NoError(client.Ping(context.Background()).Err())
+}
+
+// newMiniCluster returns a cluster client spreading the hash slots over several miniredis servers.
+func newMiniCluster(s *suite.Suite, nodes int) (*redis.ClusterClient, []*miniredis.Miniredis) {
+	servers := make([]*miniredis.Miniredis, nodes)
+	slots := make([]redis.ClusterSlot, nodes)
+	for i := range servers {
+		servers[i] = miniredis.RunT(s.T())
+		slots[i] = redis.ClusterSlot{
+			Start: i * 16384 / nodes,
+			End:   (i+1)*16384/nodes - 1,
+			Nodes: []redis.ClusterNode{{Addr: servers[i].Addr()}},
+		}
+	}
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		ClusterSlots: func(ctx context.Context) ([]redis.ClusterSlot, error) {
+			return slots, nil
+		},
+	})
+	return client, servers
+}
+
+func (s *RedisSuite) TestCluster() {
+	client, servers := newMiniCluster(&s.Suite, 3)
+	defer client.Close()
+	store, err := NewRedisAdapterWithClient(client, CacheConfig{Namespace: "ns"})
+	s.NoError(err)
+	defer store.Close()
+	replica, _ := NewRedisAdapterWithClient(client, CacheConfig{Namespace: "ns"})
+	defer replica.Close()
+
+	var keys []string
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("/users/%d", i)
+		keys = append(keys, key)
+		store.Set(&key, i)
+	}
+	servers[0].Set("unrelated", "data")
+	for _, server := range servers {
+		s.NotEmpty(server.Keys(), "keys are spread over every node")
+	}
+	s.ElementsMatch(keys, store.Find("/users/*"))
+	_, _, err = store.(Scanner).Scan(context.Background(), "*", 0, 10)
+	s.ErrorIs(err, ErrScanNotSupported)
+
+	// Invalidations reach other instances through the cluster
+	replica.Get("/users/1")
+	store.Set(String("/users/1"), "new")
+	s.Eventually(func() bool {
+		d, ok := replica.Get("/users/1")
+		return ok && d == "new"
+	}, time.Second, 10*time.Millisecond)
+
+	store.FlushAll()
+	s.Empty(store.Find("*"))
+	s.Eventually(func() bool {
+		_, ok := replica.(*RedisAdapter).inMemoryCache.Get("/users/1")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	s.True(servers[0].Exists("unrelated"))
+}
+
+// countingHook counts the round trips made by a client.
+type countingHook struct {
+	mu         sync.Mutex
+	roundTrips int
+}
+
+func (h *countingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *countingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.add()
+		return next(ctx, cmd)
+	}
+}
+
+func (h *countingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.add()
+		return next(ctx, cmds)
+	}
+}
+
+func (h *countingHook) add() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.roundTrips++
+}
+
+// count returns the round trips counted since the last call.
+func (h *countingHook) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := h.roundTrips
+	h.roundTrips = 0
+	return n
+}
+
+func (s *RedisSuite) TestMulti() {
+	hook := &countingHook{}
+	client := redis.NewClient(&redis.Options{Addr: s.redis.Addr()})
+	client.AddHook(hook)
+	defer client.Close()
+	bus := NewInProcessBus()
+	store, _ := NewRedisAdapterWithClient(client, CacheConfig{Namespace: "ns", InvalidationBus: bus})
+	defer store.Close()
+	replica, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{Namespace: "ns", InvalidationBus: bus})
+	defer replica.Close()
+	batch := store.(BatchAdapter)
+
+	items := make(map[string]interface{})
+	var keys []string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		items[key] = float64(i)
+		keys = append(keys, key)
+	}
+	hook.count()
+	batch.SetMulti(items, &ItemConfig{TTL: Duration(time.Hour)})
+	s.Equal(1, hook.count())
+	s.Equal(time.Hour, s.redis.TTL("ns:key0"))
+
+	// A single round trip fetches values and TTLs of the misses
+	store.Get("key0")
+	hook.count()
+	values := batch.GetMulti(append(keys, "missing"))
+	s.Equal(1, hook.count())
+	s.Equal(items, values)
+	entries := store.(BatchTTLGetter).GetMultiWithTTL(keys)
+	s.Equal(0, hook.count(), "all keys are in the local cache")
+	s.InDelta(time.Hour, entries["key5"].TTL, float64(time.Second))
+
+	// Writes drop the keys from other instances in a single event
+	replica.(BatchAdapter).GetMulti(keys)
+	batch.SetMulti(map[string]interface{}{"key1": "new", "key2": "new"})
+	s.Equal(map[string]interface{}{"key0": float64(0)}, replica.(*RedisAdapter).inMemoryCache.GetMulti(keys[:3]))
+
+	hook.count()
+	batch.DeleteMulti(keys[:10])
+	s.Equal(1, hook.count())
+	s.Len(replica.(BatchAdapter).GetMulti(keys), 10)
+	s.False(s.redis.Exists("ns:key0"))
+}
+
+func (s *RedisSuite) TestSingleRoundTrips() {
+	hook := &countingHook{}
+	client := redis.NewClient(&redis.Options{Addr: s.redis.Addr()})
+	client.AddHook(hook)
+	defer client.Close()
+	store, _ := NewRedisAdapterWithClient(client, CacheConfig{Namespace: "ns"})
+	defer store.Close()
+	replica, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{Namespace: "ns"})
+	defer replica.Close()
+	s.Eventually(func() bool {
+		return replica.(*RedisAdapter).InvalidationStatus().Connected
+	}, time.Second, 10*time.Millisecond)
+
+	// The first run of a script loads it
+	store.Set(String("warmup"), 0)
+	store.Delete("warmup")
+	hook.count()
+
+	replica.Set(String("key"), "old")
+	replica.Get("key")
+	store.Set(String("key"), "value", &ItemConfig{TTL: Duration(time.Minute)})
+	s.Equal(1, hook.count(), "SET and PUBLISH")
+	s.Equal(time.Minute, s.redis.TTL("ns:key"))
+	s.Eventually(func() bool {
+		d, ok := replica.Get("key")
+		return ok && d == "value"
+	}, time.Second, 10*time.Millisecond)
+
+	_, ttl, ok := store.(TTLGetter).GetWithTTL("key")
+	s.True(ok)
+	s.InDelta(time.Minute, ttl, float64(time.Second))
+	s.Equal(1, hook.count(), "GET and PTTL")
+
+	store.Delete("key")
+	s.Equal(1, hook.count(), "DEL and PUBLISH")
+	s.False(s.redis.Exists("ns:key"))
+	s.Eventually(func() bool {
+		_, ok := replica.Get("key")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	// Items without a TTL never expire in Redis and use the default TTL locally
+	store.Set(String("forever"), "value", &ItemConfig{TTL: Duration(0)})
+	s.Equal(time.Duration(0), s.redis.TTL("ns:forever"))
+	_, ttl, ok = store.(TTLGetter).GetWithTTL("forever")
+	s.True(ok)
+	s.Equal(time.Duration(0), ttl)
+	_, ok = store.(*RedisAdapter).inMemoryCache.Get("forever")
+	s.True(ok)
+}
+
+func (s *RedisSuite) TestMultiCluster() {
+	client, _ := newMiniCluster(&s.Suite, 3)
+	defer client.Close()
+	store, _ := NewRedisAdapterWithClient(client)
+	defer store.Close()
+	items := make(map[string]interface{})
+	var keys []string
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key%d", i)
+		items[key] = float64(i)
+		keys = append(keys, key)
+	}
+	SetMulti(store, items)
+	store.(*RedisAdapter).inMemoryCache.FlushAll()
+	s.Equal(items, GetMulti(store, keys))
+	DeleteMulti(store, keys)
+	s.Empty(store.Find("*"))
+}
+
 func TestRedisSuite(t *testing.T) {
 	suite.Run(t, new(RedisSuite))
 }
@@ -0,0 +1,248 @@
+package ginche
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Fields of cached HTTP responses stored as Redis hashes
+const (
+	redisFieldStatus   = "status"
+	redisFieldHeaders  = "headers"
+	redisFieldBody     = "body"
+	redisFieldSize     = "size"
+	redisFieldETag     = "etag"
+	redisFieldStoredAt = "stored_at"
+	// redisFieldCompressed is set if the body is compressed, see CacheConfig.Compression
+	redisFieldCompressed = "compressed"
+	// redisFieldBodySum and redisFieldChecksum are the integrity checks of the body and of all other fields,
+	// see CacheConfig.VerifyIntegrity. Metadata is verified without reading the body.
+	redisFieldBodySum  = "body_sum"
+	redisFieldChecksum = "checksum"
+)
+
+// redisMetaFields are the fields read by GetMeta
+var redisMetaFields = []string{redisFieldStatus, redisFieldHeaders, redisFieldSize, redisFieldETag, redisFieldStoredAt,
+	redisFieldCompressed, redisFieldBodySum, redisFieldChecksum}
+
+var errNotResponse = errors.New("ginche: not a cached response")
+
+// ResponseMeta describes a cached HTTP response without its body.
+type ResponseMeta struct {
+	Status   int
+	Headers  http.Header
+	Size     int64
+	ETag     string
+	StoredAt time.Time
+}
+
+// ResponseMetaGetter is implemented by adapters that can read the metadata of a cached
+// response without transferring its body. The middleware uses it to answer conditional requests.
+type ResponseMetaGetter interface {
+	GetMeta(key string) (ResponseMeta, bool)
+}
+
+// GetMeta returns the metadata of a cached response.
+// Responses stored as hashes are read without their body,
+// other values are read whole and must be responses.
+func (r *RedisAdapter) GetMeta(key string) (ResponseMeta, bool) {
+	if value, ok := r.inMemoryCache.Get(key); ok {
+		return responseMetaOf(value)
+	}
+	if r.config.HashResponses {
+		fields, err := r.conn.HMGet(context.Background(), r.prefix+key, redisMetaFields...).Result()
+		if err == nil {
+			values := make(map[string]string, len(fields))
+			for i, name := range redisMetaFields {
+				if v, ok := fields[i].(string); ok {
+					values[name] = v
+				}
+			}
+			if _, ok := values[redisFieldStatus]; !ok {
+				return ResponseMeta{}, false
+			}
+			if err := r.verifyHash(key, values, false); err != nil {
+				r.corrupted(key, err)
+				return ResponseMeta{}, false
+			}
+			meta, err := decodeResponseMeta(values)
+			return meta, err == nil
+		}
+	}
+	value, ok := r.Get(key)
+	if !ok {
+		return ResponseMeta{}, false
+	}
+	return responseMetaOf(value)
+}
+
+// readsMetaWithoutBody reports whether GetMeta skips the body of stored responses,
+// which makes it worth answering conditional requests before reading the whole response.
+func (r *RedisAdapter) readsMetaWithoutBody() bool {
+	return r.config.HashResponses
+}
+
+// responseMetaOf returns the metadata of a response decoded from any storage format.
+func responseMetaOf(value interface{}) (ResponseMeta, bool) {
+	item, ok := asHTTPCacheItem(value)
+	if !ok {
+		return ResponseMeta{}, false
+	}
+	body, _ := item.Data.(string)
+	return ResponseMeta{
+		Status:  item.Status,
+		Headers: item.Headers,
+		Size:    int64(len(body)),
+		ETag:    item.Headers.Get("ETag"),
+	}, true
+}
+
+// responseHash returns the hash fields of a cached response,
+// or false if the value is not a response with a string body.
+func responseHash(value interface{}) (map[string]interface{}, bool) {
+	var item *httpCacheItem
+	switch v := value.(type) {
+	case *httpCacheItem:
+		item = v
+	case httpCacheItem:
+		item = &v
+	default:
+		return nil, false
+	}
+	body, ok := item.Data.(string)
+	if !ok {
+		return nil, false
+	}
+	headers, err := json.Marshal(item.Headers)
+	if err != nil {
+		return nil, false
+	}
+	return map[string]interface{}{
+		redisFieldStatus:   strconv.Itoa(item.Status),
+		redisFieldHeaders:  string(headers),
+		redisFieldBody:     body,
+		redisFieldSize:     strconv.Itoa(len(body)),
+		redisFieldETag:     item.Headers.Get("ETag"),
+		redisFieldStoredAt: strconv.FormatInt(time.Now().UnixMilli(), 10),
+	}, true
+}
+
+// decodeResponseHash returns the response stored in the hash fields of the key.
+func (r *RedisAdapter) decodeResponseHash(key string, fields map[string]string) (*httpCacheItem, error) {
+	if err := r.verifyHash(key, fields, true); err != nil {
+		return nil, err
+	}
+	meta, err := decodeResponseMeta(fields)
+	if err != nil {
+		return nil, err
+	}
+	body, ok := fields[redisFieldBody]
+	if !ok {
+		return nil, errNotResponse
+	}
+	if _, compressed := fields[redisFieldCompressed]; compressed {
+		b, err := r.codec.decode([]byte(body))
+		if err != nil {
+			return nil, err
+		}
+		body = string(b)
+	}
+	return &httpCacheItem{Status: meta.Status, Headers: meta.Headers, Data: body}, nil
+}
+
+func decodeResponseMeta(fields map[string]string) (ResponseMeta, error) {
+	var meta ResponseMeta
+	var err error
+	if meta.Status, err = strconv.Atoi(fields[redisFieldStatus]); err != nil {
+		return meta, errNotResponse
+	}
+	if err = json.Unmarshal([]byte(fields[redisFieldHeaders]), &meta.Headers); err != nil {
+		return meta, err
+	}
+	meta.Size, _ = strconv.ParseInt(fields[redisFieldSize], 10, 64)
+	meta.ETag = fields[redisFieldETag]
+	if ms, err := strconv.ParseInt(fields[redisFieldStoredAt], 10, 64); err == nil {
+		meta.StoredAt = time.UnixMilli(ms)
+	}
+	return meta, nil
+}
+
+// setHash queues the commands replacing the key with the hash fields.
+func (r *RedisAdapter) setHash(ctx context.Context, pipe redis.Pipeliner, key string, fields map[string]interface{}, ttl time.Duration) {
+	pipe.Del(ctx, r.prefix+key)
+	pipe.HSet(ctx, r.prefix+key, fields)
+	if ttl > 0 {
+		pipe.PExpire(ctx, r.prefix+key, ttl)
+	}
+}
+
+// hashChecksum returns the integrity check of all response fields but the body,
+// which is covered by its own check.
+func (r *RedisAdapter) hashChecksum(key string, field func(name string) string) []byte {
+	parts := make([][]byte, 0, len(redisMetaFields)-1)
+	for _, name := range redisMetaFields {
+		if name != redisFieldChecksum {
+			parts = append(parts, []byte(field(name)))
+		}
+	}
+	return r.codec.sum(r.prefix+key, parts...)
+}
+
+// verifyHash checks the integrity of the response fields and, if withBody is set, of the body.
+// Hashes without checks are accepted unless IntegritySecret is set.
+func (r *RedisAdapter) verifyHash(key string, fields map[string]string, withBody bool) error {
+	checksum, ok := fields[redisFieldChecksum]
+	if !ok {
+		if r.codec.secret != nil {
+			return ErrIntegrity
+		}
+		return nil
+	}
+	if !hmac.Equal([]byte(checksum), r.hashChecksum(key, func(name string) string { return fields[name] })) {
+		return ErrIntegrity
+	}
+	if withBody && !hmac.Equal([]byte(fields[redisFieldBodySum]), r.codec.sum(r.prefix+key, []byte(fields[redisFieldBody]))) {
+		return ErrIntegrity
+	}
+	return nil
+}
+
+// decodeReply decodes a value of the key read with GET or, for responses stored as hashes, HGETALL.
+// hash is nil if HashResponses is disabled. Entries that can not be decoded are reported as corrupted.
+func (r *RedisAdapter) decodeReply(key string, value *string, hash *redis.MapStringStringCmd) (interface{}, bool) {
+	if value != nil {
+		b, err := r.codec.open(r.prefix+key, []byte(*value))
+		if err != nil {
+			r.corrupted(key, err)
+			return nil, false
+		}
+		var data item
+		if err := json.Unmarshal(b, &data); err != nil {
+			r.corrupted(key, err)
+			return nil, false
+		}
+		return data.Data, true
+	}
+	if hash == nil || hash.Err() != nil || len(hash.Val()) == 0 {
+		return nil, false
+	}
+	response, err := r.decodeResponseHash(key, hash.Val())
+	if err != nil {
+		r.corrupted(key, err)
+		return nil, false
+	}
+	return response, true
+}
+
+// corrupted deletes an entry that failed verification or decoding if VerifyIntegrity is enabled.
+func (r *RedisAdapter) corrupted(key string, err error) {
+	if r.codec.corrupted(key, err) {
+		r.Delete(key)
+	}
+}
@@ -0,0 +1,257 @@
+package ginche
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+func (s *RedisSuite) newHashServer(store CacheAdapter) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware(store, nil))
+	r.GET("/test", func(ctx *gin.Context) {
+		ctx.Header("ETag", `"v1"`)
+		ctx.Header("Cache-Control", "max-age=60")
+		ctx.String(http.StatusAccepted, `{"data":"te\"st"}`)
+	})
+	return r
+}
+
+func (s *RedisSuite) request(r *gin.Engine, header http.Header) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/test", nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func (s *RedisSuite) TestHashResponses() {
+	store, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{Namespace: "ns", HashResponses: true})
+	defer store.Close()
+	r := s.newHashServer(store)
+
+	w := s.request(r, nil)
+	s.Equal(HeaderXCacheMiss, w.Header().Get(HeaderXCache))
+	s.True(s.redis.Exists("ns:/test"))
+	s.Equal("202", s.redis.HGet("ns:/test", "status"))
+	s.Equal(`{"data":"te\"st"}`, s.redis.HGet("ns:/test", "body"), "the body is stored raw")
+	s.Equal(`"v1"`, s.redis.HGet("ns:/test", "etag"))
+	s.Equal("17", s.redis.HGet("ns:/test", "size"))
+	s.Equal(time.Minute*5, s.redis.TTL("ns:/test"))
+
+	store.(*RedisAdapter).inMemoryCache.FlushAll()
+	w = s.request(r, nil)
+	s.Equal(HeaderXCacheHit, w.Header().Get(HeaderXCache))
+	s.Equal(http.StatusAccepted, w.Code)
+	s.Equal(`{"data":"te\"st"}`, w.Body.String())
+	s.Equal(`"v1"`, w.Header().Get("ETag"))
+
+	store.(*RedisAdapter).inMemoryCache.FlushAll()
+	meta, ok := store.(ResponseMetaGetter).GetMeta("/test")
+	s.True(ok)
+	s.Equal(http.StatusAccepted, meta.Status)
+	s.Equal(int64(17), meta.Size)
+	s.Equal(`"v1"`, meta.ETag)
+	s.Equal("max-age=60", meta.Headers.Get("Cache-Control"))
+	s.WithinDuration(time.Now(), meta.StoredAt, time.Minute)
+	_, ok = store.(*RedisAdapter).inMemoryCache.Get("/test")
+	s.False(ok, "metadata reads do not fill the local cache")
+
+	_, ok = store.(ResponseMetaGetter).GetMeta("/missing")
+	s.False(ok)
+}
+
+func (s *RedisSuite) TestConditionalRequests() {
+	store, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{HashResponses: true})
+	defer store.Close()
+	r := s.newHashServer(store)
+	s.request(r, nil)
+	store.(*RedisAdapter).inMemoryCache.FlushAll()
+
+	w := s.request(r, http.Header{"If-None-Match": {`"v0", W/"v1"`}})
+	s.Equal(http.StatusNotModified, w.Code)
+	s.Empty(w.Body.String())
+	s.Equal(`"v1"`, w.Header().Get("ETag"))
+	s.Equal("max-age=60", w.Header().Get("Cache-Control"))
+	s.Equal(HeaderXCacheHit, w.Header().Get(HeaderXCache))
+
+	w = s.request(r, http.Header{"If-None-Match": {`"v0"`}})
+	s.Equal(http.StatusAccepted, w.Code)
+	s.Equal(`{"data":"te\"st"}`, w.Body.String())
+}
+
+func (s *RedisSuite) TestConditionalRequestsWithoutHashes() {
+	store, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()})
+	defer store.Close()
+	r := s.newHashServer(store)
+	s.request(r, nil)
+	store.(*RedisAdapter).inMemoryCache.FlushAll()
+
+	w := s.request(r, http.Header{"If-None-Match": {`"v1"`}})
+	s.Equal(http.StatusAccepted, w.Code)
+	s.Equal(`{"data":"te\"st"}`, w.Body.String())
+	s.Equal(HeaderXCacheHit, w.Header().Get(HeaderXCache))
+}
+
+func (s *RedisSuite) TestHashAndStringValuesCoexist() {
+	legacy, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()})
+	defer legacy.Close()
+	store, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{HashResponses: true})
+	defer store.Close()
+
+	response := &httpCacheItem{Status: 200, Headers: http.Header{"Etag": {`"old"`}}, Data: "old"}
+	legacy.Set(String("old"), response)
+	store.Set(String("new"), &httpCacheItem{Status: 201, Headers: http.Header{}, Data: "new"})
+	store.Set(String("plain"), "value")
+	s.Equal("201", s.redis.HGet("new", "status"))
+	s.Equal("new", s.redis.HGet("new", "body"))
+
+	values := GetMulti(store, []string{"old", "new", "plain", "missing"})
+	s.Len(values, 3)
+	s.Equal("value", values["plain"])
+	s.Equal(&httpCacheItem{Status: 201, Headers: http.Header{}, Data: "new"}, values["new"])
+	old, ok := asHTTPCacheItem(values["old"])
+	s.True(ok)
+	s.Equal("old", old.Data)
+
+	store.(*RedisAdapter).inMemoryCache.FlushAll()
+	meta, ok := store.(ResponseMetaGetter).GetMeta("old")
+	s.True(ok)
+	s.Equal(`"old"`, meta.ETag)
+	_, ok = store.(ResponseMetaGetter).GetMeta("plain")
+	s.False(ok)
+
+	// Overwriting a hash with a string value and back
+	store.Set(String("new"), "string")
+	d, _, _ := store.(TTLGetter).GetWithTTL("new")
+	s.Equal("string", d)
+	SetMulti(store, map[string]interface{}{"new": response})
+	store.(*RedisAdapter).inMemoryCache.FlushAll()
+	d, ok = store.Get("new")
+	s.True(ok)
+	s.Equal(response, d)
+}
+
+func (s *RedisSuite) TestCompression() {
+	value := strings.Repeat("compressible ", 1000)
+	s.store.Set(String("plain"), value)
+
+	store, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{Compression: CompressionGzip, CompressionThreshold: 64})
+	defer store.Close()
+	store.Set(String("small"), "value")
+	SetMulti(store, map[string]interface{}{"large": value})
+	stored, _ := s.redis.Get("large")
+	s.Equal(compressedGzip, stored[0])
+	s.Less(len(stored), len(value)/10)
+	stored, _ = s.redis.Get("small")
+	s.Equal(`{"Data":"value"}`, stored)
+
+	// Values written with and without compression are read by both adapters
+	for _, adapter := range []CacheAdapter{s.store, store} {
+		adapter.(*RedisAdapter).inMemoryCache.FlushAll()
+		values := GetMulti(adapter, []string{"plain", "small", "large"})
+		s.Equal(map[string]interface{}{"plain": value, "small": "value", "large": value}, values)
+	}
+
+	stats := store.(*RedisAdapter).CompressionStats()
+	s.Equal(uint64(2), stats.Values)
+	s.Equal(uint64(1), stats.Compressed)
+	s.Greater(stats.Ratio, 0.0)
+	s.Less(stats.Ratio, 0.1)
+}
+
+func (s *RedisSuite) TestCompressedHashResponses() {
+	store, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{HashResponses: true, Compression: CompressionFlate})
+	defer store.Close()
+	body := strings.Repeat("compressible ", 1000)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware(store, nil))
+	r.GET("/test", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, body)
+	})
+
+	s.request(r, nil)
+	s.Equal("1", s.redis.HGet("/test", "compressed"))
+	s.Equal(compressedFlate, s.redis.HGet("/test", "body")[0])
+	s.Equal("13000", s.redis.HGet("/test", "size"))
+
+	store.(*RedisAdapter).inMemoryCache.FlushAll()
+	w := s.request(r, nil)
+	s.Equal(HeaderXCacheHit, w.Header().Get(HeaderXCache))
+	s.Equal(body, w.Body.String())
+}
+
+func (s *RedisSuite) TestIntegrity() {
+	var reported []string
+	store, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{
+		Namespace:       "ns",
+		VerifyIntegrity: true,
+		OnIntegrityError: func(key string, err error) {
+			s.ErrorIs(err, ErrIntegrity)
+			reported = append(reported, key)
+		},
+	})
+	defer store.Close()
+	local := store.(*RedisAdapter).inMemoryCache
+
+	store.Set(String("valid"), "value")
+	store.Set(String("tampered"), "value")
+	stored, _ := s.redis.Get("ns:tampered")
+	s.Require().NoError(s.redis.Set("ns:tampered", strings.Replace(stored, "value", "evil!", 1)))
+	local.FlushAll()
+
+	s.Equal(map[string]interface{}{"valid": "value"}, GetMulti(store, []string{"valid", "tampered"}))
+	s.Equal([]string{"tampered"}, reported)
+	s.False(s.redis.Exists("ns:tampered"), "corrupted entries are deleted")
+
+	// Entries that are not even JSON are reported too
+	s.Require().NoError(s.redis.Set("ns:garbage", "{garbage"))
+	_, ok := store.Get("garbage")
+	s.False(ok)
+	s.Equal([]string{"tampered", "garbage"}, reported)
+	s.False(s.redis.Exists("ns:garbage"))
+}
+
+func (s *RedisSuite) TestSignedHashResponses() {
+	var reported []string
+	store, _ := NewRedisAdapter(&redis.Options{Addr: s.redis.Addr()}, CacheConfig{
+		HashResponses:    true,
+		IntegritySecret:  []byte("secret"),
+		OnIntegrityError: func(key string, err error) { reported = append(reported, key) },
+	})
+	defer store.Close()
+	local := store.(*RedisAdapter).inMemoryCache
+	r := s.newHashServer(store)
+
+	s.request(r, nil)
+	local.FlushAll()
+	meta, ok := store.(ResponseMetaGetter).GetMeta("/test")
+	s.True(ok)
+	s.Equal(`"v1"`, meta.ETag)
+	w := s.request(r, nil)
+	s.Equal(HeaderXCacheHit, w.Header().Get(HeaderXCache))
+
+	// A tampered body fails on full reads
+	s.redis.HSet("/test", "body", "evil")
+	local.FlushAll()
+	_, ok = store.Get("/test")
+	s.False(ok)
+	s.Equal([]string{"/test"}, reported)
+	s.False(s.redis.Exists("/test"))
+
+	// A tampered ETag fails on metadata reads
+	s.request(r, nil)
+	s.redis.HSet("/test", "etag", `"v2"`)
+	local.FlushAll()
+	_, ok = store.(ResponseMetaGetter).GetMeta("/test")
+	s.False(ok)
+	s.Equal([]string{"/test", "/test"}, reported)
+	s.False(s.redis.Exists("/test"))
+}
@@ -0,0 +1,244 @@
+package ginche
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultShards is the number of shards used when CacheConfig.Shards is not set.
+	defaultShards = 32
+	// minShardEntries is the smallest share of MaxEntries a default shard gets.
+	minShardEntries = 64
+	// minShardBytes is the smallest share of MaxBytes a default shard gets.
+	minShardBytes = 1 << 20
+)
+
+// cacheShard is a part of InMemoryCache guarded by its own lock.
+// It holds its own items, eviction policy and expiry heap.
+// Its entry and byte counts are also added to the limits shared by all shards,
+// they are written under the lock and can be read atomically without it.
+type cacheShard struct {
+	entries int64
+	bytes   int64
+	mu      sync.Mutex
+	items   map[string]*Item
+	policy  evictionPolicy
+	expiry  expiryHeap
+	limits  *cacheLimits
+}
+
+func newCacheShard(conf CacheConfig, limits *cacheLimits, shards int) *cacheShard {
+	s := &cacheShard{
+		items:  make(map[string]*Item),
+		limits: limits,
+	}
+	s.policy = newEvictionPolicy(conf, shards)
+	return s
+}
+
+// cacheLimits holds the limits of InMemoryCache and the entries and bytes of all its shards,
+// so MaxEntries and MaxBytes apply to the whole cache whatever the distribution of keys.
+type cacheLimits struct {
+	entries      int64
+	bytes        int64
+	maxEntries   int64
+	maxBytes     int64
+	maxItemBytes int64
+}
+
+// tooLarge reports whether an item of the given size can never fit into the cache.
+func (l *cacheLimits) tooLarge(size int64) bool {
+	return (l.maxItemBytes > 0 && size > l.maxItemBytes) || (l.maxBytes > 0 && size > l.maxBytes)
+}
+
+// overflows reports whether the cache exceeds its entry or byte limit.
+func (l *cacheLimits) overflows() (entries bool, bytes bool) {
+	return l.maxEntries > 0 && atomic.LoadInt64(&l.entries) > l.maxEntries,
+		l.maxBytes > 0 && atomic.LoadInt64(&l.bytes) > l.maxBytes
+}
+
+// set stores the item. Items over the limits are evicted by InMemoryCache afterwards.
+func (s *cacheShard) set(key string, value interface{}, size int64, expiresAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setLocked(key, value, size, expiresAt)
+}
+
+// setMulti stores the items under a single lock.
+func (s *cacheShard) setMulti(items []*Item) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range items {
+		s.setLocked(item.key, item.value, item.size, item.expiresAt)
+	}
+}
+
+// setLocked stores the item, the caller must hold s.mu.
+func (s *cacheShard) setLocked(key string, value interface{}, size int64, expiresAt time.Time) {
+	s.policy.record(key)
+	if s.limits.tooLarge(size) {
+		if item, ok := s.items[key]; ok {
+			s.removeItem(item)
+		}
+		return
+	}
+	if item, ok := s.items[key]; ok {
+		s.account(0, size-item.size)
+		item.value = value
+		item.size = size
+		item.expiresAt = expiresAt
+		s.expiry.update(item)
+		s.policy.access(item)
+	} else {
+		item = &Item{key: key, value: value, size: size, expiresAt: expiresAt}
+		s.items[key] = item
+		s.expiry.add(item)
+		s.policy.add(item)
+		s.account(1, size)
+	}
+}
+
+// evict removes the item chosen by the eviction policy and returns it, or nil if the shard is empty.
+func (s *cacheShard) evict() *Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.items) == 0 {
+		return nil
+	}
+	return s.removeItem(s.policy.victim())
+}
+
+// account adds to the entry and byte counts of the shard and of the whole cache.
+// The caller must hold s.mu.
+func (s *cacheShard) account(entries, bytes int64) {
+	atomic.AddInt64(&s.entries, entries)
+	atomic.AddInt64(&s.bytes, bytes)
+	atomic.AddInt64(&s.limits.entries, entries)
+	atomic.AddInt64(&s.limits.bytes, bytes)
+}
+
+// get returns the value of the item and its expiration time,
+// removing the item if it has expired.
+func (s *cacheShard) get(key string) (interface{}, time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getLocked(key)
+}
+
+// getMulti adds the found keys to entries under a single lock.
+func (s *cacheShard) getMulti(keys []string, entries map[string]Entry, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		if value, expiresAt, ok := s.getLocked(key); ok {
+			entries[key] = Entry{Value: value, TTL: expiresAt.Sub(now)}
+		}
+	}
+}
+
+// getLocked works like get, the caller must hold s.mu.
+func (s *cacheShard) getLocked(key string) (interface{}, time.Time, bool) {
+	s.policy.record(key)
+	item, ok := s.items[key]
+	if !ok {
+		return nil, time.Time{}, ok
+	}
+	if time.Now().After(item.expiresAt) {
+		s.removeItem(item)
+		return nil, time.Time{}, false
+	}
+	s.policy.access(item)
+	return item.value, item.expiresAt, true
+}
+
+// exists reports whether the key is cached and not expired, without counting it as an access.
+func (s *cacheShard) exists(key string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	return ok && item.expiresAt.After(now)
+}
+
+// appendMatching appends keys of unexpired items matching the pattern
+// and returns the number of items it examined.
+func (s *cacheShard) appendMatching(keys []string, g globPattern, now time.Time) ([]string, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, item := range s.items {
+		if item.expiresAt.After(now) && g.match(k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, len(s.items)
+}
+
+func (s *cacheShard) delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item, ok := s.items[key]; ok {
+		s.removeItem(item)
+	}
+}
+
+func (s *cacheShard) deleteMulti(keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		if item, ok := s.items[key]; ok {
+			s.removeItem(item)
+		}
+	}
+}
+
+func (s *cacheShard) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = make(map[string]*Item)
+	s.expiry = nil
+	s.policy.reset()
+	s.account(-atomic.LoadInt64(&s.entries), -atomic.LoadInt64(&s.bytes))
+}
+
+// deleteExpired removes all items that expired before now.
+// It only visits expired items and returns the expiration time of the
+// next item, or false if the shard is empty.
+func (s *cacheShard) deleteExpired(now time.Time) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for item := s.expiry.peek(); item != nil && now.After(item.expiresAt); item = s.expiry.peek() {
+		s.removeItem(item)
+	}
+	if item := s.expiry.peek(); item != nil {
+		return item.expiresAt, true
+	}
+	return time.Time{}, false
+}
+
+// removeItem unlinks the item from the shard and the eviction policy.
+// The caller must hold s.mu.
+func (s *cacheShard) removeItem(item *Item) *Item {
+	s.policy.remove(item)
+	s.expiry.remove(item)
+	delete(s.items, item.key)
+	s.account(-1, -item.size)
+	return item
+}
+
+func ceilDiv(a, b int64) int64 {
+	return (a + b - 1) / b
+}
+
+// snapshot returns copies of the unexpired items.
+func (s *cacheShard) snapshot(now time.Time) []Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]Item, 0, len(s.items))
+	for _, item := range s.items {
+		if item.expiresAt.After(now) {
+			items = append(items, Item{key: item.key, value: item.value, expiresAt: item.expiresAt})
+		}
+	}
+	return items
+}
@@ -0,0 +1,77 @@
+package ginche
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardCount(t *testing.T) {
+	tests := []struct {
+		name string
+		conf CacheConfig
+		want int
+	}{
+		{"unbounded", CacheConfig{}, defaultShards},
+		{"explicit", CacheConfig{Shards: 5}, 8},
+		{"explicit over entries", CacheConfig{Shards: 5, MaxEntries: 2}, 2},
+		{"few entries", CacheConfig{MaxEntries: 2}, 1},
+		{"entries", CacheConfig{MaxEntries: 64 * 8}, 8},
+		{"few bytes", CacheConfig{MaxBytes: 10}, 1},
+		{"large items", CacheConfig{MaxBytes: 64 << 20, MaxItemBytes: 16 << 20}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shardCount(tt.conf); got != tt.want {
+				t.Errorf("shardCount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShardLimits(t *testing.T) {
+	cache := NewInMemoryCache(CacheConfig{Shards: 8, MaxEntries: 3}).(*InMemoryCache)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		cache.Set(&key, i)
+	}
+	if n := cache.Len(); n != 3 {
+		t.Errorf("Len() = %v, want 3", n)
+	}
+}
+
+func TestShardLimitsSkewed(t *testing.T) {
+	// Keys are not spread evenly, the cache still holds exactly MaxEntries items
+	cache := NewInMemoryCache(CacheConfig{MaxEntries: 10000}).(*InMemoryCache)
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		cache.Set(&key, i)
+	}
+	if n := cache.Len(); n != 10000 {
+		t.Errorf("Len() = %v, want 10000", n)
+	}
+}
+
+func TestShardLargeItem(t *testing.T) {
+	cache := NewInMemoryCache(CacheConfig{
+		MaxBytes: 10 << 20,
+		SizeFunc: func(key string, value interface{}) int64 {
+			return int64(len(value.([]byte)))
+		},
+	}).(*InMemoryCache)
+	if len(cache.shards) < 2 {
+		t.Fatalf("got %v shards, want several", len(cache.shards))
+	}
+	// Larger than the share of MaxBytes of a single shard
+	large := make([]byte, 3<<20)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("small%d", i)
+		cache.Set(&key, make([]byte, 1<<20))
+		cache.Set(String("large"), large)
+		if _, ok := cache.Get("large"); !ok {
+			t.Fatalf("large item was rejected after %v small items", i+1)
+		}
+	}
+	if cache.limits.bytes > 10<<20 {
+		t.Errorf("cache holds %v bytes, want at most %v", cache.limits.bytes, 10<<20)
+	}
+}
@@ -0,0 +1,64 @@
+package ginche
+
+import (
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// ShardedRedisOptions is used to configure a ShardedRedisAdapter.
+// Nodes maps node names to addresses. Keys are placed by rendezvous hashing of the names,
+// so a node can move to another address without moving its keys.
+// Options is the template for the node clients, its Addr is ignored.
+// HeartbeatFrequency is how often nodes are pinged, a node is skipped
+// after 3 failed pings until it answers again. It defaults to 500ms.
+type ShardedRedisOptions struct {
+	Nodes              map[string]string
+	Options            *redis.Options
+	HeartbeatFrequency time.Duration
+}
+
+// ShardedRedisAdapter spreads keys over several standalone Redis servers.
+// It is a RedisAdapter on top of a *redis.Ring, which places keys with rendezvous hashing:
+// adding or removing a node only moves the keys that belong to that node.
+// Find and FlushAll visit every node, Scan returns ErrScanNotSupported.
+type ShardedRedisAdapter struct {
+	*RedisAdapter
+	ring *redis.Ring
+}
+
+// NewShardedRedisAdapter creates a client for every node and an adapter spreading keys over them.
+// The clients are closed with the adapter.
+func NewShardedRedisAdapter(options *ShardedRedisOptions, config ...CacheConfig) (CacheAdapter, error) {
+	var opts ShardedRedisOptions
+	if options != nil {
+		opts = *options
+	}
+	template := opts.Options
+	if template == nil {
+		template = &redis.Options{}
+	}
+	ring := redis.NewRing(&redis.RingOptions{
+		Addrs:              opts.Nodes,
+		HeartbeatFrequency: opts.HeartbeatFrequency,
+		NewClient: func(opt *redis.Options) *redis.Client {
+			node := *template
+			node.Addr = opt.Addr
+			return redis.NewClient(&node)
+		},
+	})
+	adapter, err := newRedisAdapter(ring, true, config...)
+	if err != nil {
+		// The ring is already closed if the ping failed, closing it again is harmless
+		ring.Close()
+		return nil, err
+	}
+	return &ShardedRedisAdapter{RedisAdapter: adapter.(*RedisAdapter), ring: ring}, nil
+}
+
+// SetNodes replaces the set of nodes. Only the keys of removed nodes and the keys
+// taken over by added nodes move, they are misses until they are set again.
+// Every instance sharing the nodes should be updated, otherwise they place keys differently.
+// Keys left on a node that is added back are found again until they expire.
+func (r *ShardedRedisAdapter) SetNodes(nodes map[string]string) {
+	r.ring.SetAddrs(nodes)
+}
@@ -0,0 +1,156 @@
+package ginche
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type ShardedRedisSuite struct {
+	suite.Suite
+	servers map[string]*miniredis.Miniredis
+	store   *ShardedRedisAdapter
+}
+
+func (s *ShardedRedisSuite) SetupTest() {
+	s.servers = make(map[string]*miniredis.Miniredis)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		s.servers[name] = miniredis.RunT(s.T())
+	}
+	store, err := NewShardedRedisAdapter(&ShardedRedisOptions{Nodes: s.nodes("a", "b", "c")}, CacheConfig{Namespace: "ns"})
+	s.NoError(err)
+	s.store = store.(*ShardedRedisAdapter)
+}
+
+func (s *ShardedRedisSuite) TearDownTest() {
+	s.NoError(s.store.Close())
+}
+
+func (s *ShardedRedisSuite) nodes(names ...string) map[string]string {
+	nodes := make(map[string]string)
+	for _, name := range names {
+		nodes[name] = s.servers[name].Addr()
+	}
+	return nodes
+}
+
+// locate returns the name of the server holding the key.
+func (s *ShardedRedisSuite) locate(key string) string {
+	var found string
+	for name, server := range s.servers {
+		if server.Exists("ns:" + key) {
+			s.Empty(found, "key %s is stored once", key)
+			found = name
+		}
+	}
+	return found
+}
+
+func (s *ShardedRedisSuite) setKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("/users/%d", i)
+		s.store.Set(&keys[i], i)
+	}
+	return keys
+}
+
+func (s *ShardedRedisSuite) TestSpreadsKeys() {
+	keys := s.setKeys(300)
+	counts := make(map[string]int)
+	for _, key := range keys {
+		counts[s.locate(key)]++
+	}
+	s.Len(counts, 3)
+	for _, name := range []string{"a", "b", "c"} {
+		s.Greater(counts[name], 50, name)
+	}
+	s.store.inMemoryCache.FlushAll()
+	for i, key := range keys {
+		d, ok := s.store.Get(key)
+		s.True(ok)
+		s.Equal(float64(i), d)
+	}
+}
+
+func (s *ShardedRedisSuite) TestFindAndFlushAll() {
+	keys := s.setKeys(100)
+	s.servers["a"].Set("unrelated", "data")
+	s.ElementsMatch(keys, s.store.Find("/users/*"))
+	_, _, err := s.store.Scan(context.Background(), "*", 0, 10)
+	s.ErrorIs(err, ErrScanNotSupported)
+
+	s.store.FlushAll()
+	s.Empty(s.store.Find("*"))
+	for _, key := range keys {
+		s.Empty(s.locate(key))
+	}
+	s.True(s.servers["a"].Exists("unrelated"))
+}
+
+func (s *ShardedRedisSuite) TestRebalancesMinimally() {
+	keys := s.setKeys(300)
+	before := make(map[string]string)
+	for _, key := range keys {
+		before[key] = s.locate(key)
+	}
+
+	// Removing a node only loses its own keys
+	s.store.SetNodes(s.nodes("a", "b"))
+	s.store.inMemoryCache.FlushAll()
+	for _, key := range keys {
+		_, ok := s.store.Get(key)
+		s.Equal(before[key] != "c", ok, key)
+	}
+
+	// Adding a node only takes keys over, they never move between old nodes
+	s.store.SetNodes(s.nodes("a", "b", "c", "d"))
+	s.store.FlushAll()
+	s.setKeys(300)
+	moved := 0
+	for _, key := range keys {
+		if after := s.locate(key); after != before[key] {
+			s.Equal("d", after, key)
+			moved++
+		}
+	}
+	s.Greater(moved, 30)
+	s.Less(moved, 150)
+}
+
+func (s *ShardedRedisSuite) TestInvalidatesOtherInstances() {
+	replica, err := NewShardedRedisAdapter(&ShardedRedisOptions{Nodes: s.nodes("a", "b", "c")}, CacheConfig{Namespace: "ns"})
+	s.NoError(err)
+	defer replica.Close()
+	s.Eventually(func() bool {
+		return replica.(*ShardedRedisAdapter).InvalidationStatus().Connected
+	}, time.Second, 10*time.Millisecond)
+
+	s.store.Set(String("key"), "old")
+	replica.Get("key")
+	s.store.Set(String("key"), "new")
+	s.Eventually(func() bool {
+		d, ok := replica.Get("key")
+		return ok && d == "new"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func (s *ShardedRedisSuite) TestNodeOptions() {
+	s.servers["a"].RequireAuth("secret")
+	store, err := NewShardedRedisAdapter(&ShardedRedisOptions{
+		Nodes:   s.nodes("a"),
+		Options: &redis.Options{Password: "secret"},
+	})
+	s.NoError(err)
+	defer store.Close()
+	store.Set(String("key"), "value")
+	s.True(s.servers["a"].Exists("key"))
+}
+
+func TestShardedRedisSuite(t *testing.T) {
+	suite.Run(t, new(ShardedRedisSuite))
+}
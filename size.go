@@ -0,0 +1,42 @@
+package ginche
+
+import "encoding/json"
+
+// SizeOf returns the number of bytes accounted for the given key and value.
+// Sizes of strings, byte slices and cached HTTP responses (body and headers) are exact.
+// Any other value is measured by the length of its JSON encoding,
+// use CacheConfig.SizeFunc for cheaper or more accurate accounting.
+func SizeOf(key string, value interface{}) int64 {
+	return int64(len(key)) + valueSize(value)
+}
+
+func valueSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case *httpCacheItem:
+		return httpCacheItemSize(v)
+	case httpCacheItem:
+		return httpCacheItemSize(&v)
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	return int64(len(b))
+}
+
+// httpCacheItemSize returns the size of the response body and all header keys and values.
+func httpCacheItemSize(item *httpCacheItem) int64 {
+	size := valueSize(item.Data)
+	for k, values := range item.Headers {
+		for _, v := range values {
+			size += int64(len(k) + len(v))
+		}
+	}
+	return size
+}
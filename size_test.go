@@ -0,0 +1,32 @@
+package ginche
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSizeOf(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+		want  int64
+	}{
+		{"nil", "k", nil, 1},
+		{"string", "key", "value", 8},
+		{"bytes", "key", []byte("value"), 8},
+		{"http item", "/test", &httpCacheItem{
+			Status:  http.StatusOK,
+			Headers: http.Header{"Content-Type": []string{"text/plain"}},
+			Data:    "pong",
+		}, 5 + 12 + 10 + 4},
+		{"json fallback", "k", map[string]int{"a": 1}, 1 + 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SizeOf(tt.key, tt.value); got != tt.want {
+				t.Errorf("SizeOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
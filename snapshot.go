@@ -0,0 +1,132 @@
+package ginche
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotVersion is the version of the snapshot format written by Snapshot
+const snapshotVersion = 1
+
+// snapshotHeader is the first line of a snapshot.
+type snapshotHeader struct {
+	Version int `json:"ginche_snapshot"`
+}
+
+// snapshotEntry is a line of a snapshot holding an item and the Unix time in milliseconds it expires at.
+// Cached responses are stored as such, so they are restored with their type.
+type snapshotEntry struct {
+	Key       string         `json:"k"`
+	ExpiresAt int64          `json:"e"`
+	Response  *httpCacheItem `json:"r,omitempty"`
+	Value     interface{}    `json:"v,omitempty"`
+}
+
+// Snapshot writes all unexpired items to w as JSON lines, with the time they expire at.
+// Shards are copied one at a time, so the cache can be used while the snapshot is written.
+// Items whose values can not be encoded as JSON are skipped.
+func (c *InMemoryCache) Snapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	header, _ := json.Marshal(snapshotHeader{Version: snapshotVersion})
+	bw.Write(header)
+	bw.WriteByte('\n')
+	now := time.Now()
+	for _, s := range c.shards {
+		for _, item := range s.snapshot(now) {
+			entry := snapshotEntry{Key: item.key, ExpiresAt: item.expiresAt.UnixMilli()}
+			switch v := item.value.(type) {
+			case *httpCacheItem:
+				entry.Response = v
+			case httpCacheItem:
+				entry.Response = &v
+			default:
+				entry.Value = v
+			}
+			line, err := json.Marshal(entry)
+			if err != nil {
+				continue
+			}
+			bw.Write(line)
+			// Write errors are kept by the buffer and returned by every later call
+			if err := bw.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Restore adds the items of a snapshot written by Snapshot to the cache, keeping their remaining TTL.
+// Items that expired since the snapshot was taken are skipped, limits and eviction apply like in Set.
+// Items restored before an error is returned are kept.
+func (c *InMemoryCache) Restore(r io.Reader) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return err
+	}
+	if header.Version != snapshotVersion {
+		return fmt.Errorf("ginche: unsupported snapshot version %d", header.Version)
+	}
+	for {
+		var entry snapshotEntry
+		if err := dec.Decode(&entry); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		expiresAt := time.UnixMilli(entry.ExpiresAt)
+		if !expiresAt.After(time.Now()) {
+			continue
+		}
+		var value interface{} = entry.Value
+		if entry.Response != nil {
+			value = entry.Response
+		}
+		var size int64
+		if c.sized {
+			size = c.sizeFunc(entry.Key, value)
+		}
+		c.shard(entry.Key).set(entry.Key, value, size, expiresAt)
+		c.itemsAdded(expiresAt)
+	}
+}
+
+// restoreFile restores the snapshot file, if it exists.
+func (c *InMemoryCache) restoreFile(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Restore(f)
+}
+
+// snapshotFile writes a snapshot to a temporary file and renames it to path,
+// so a crash never leaves a partial snapshot behind.
+func (c *InMemoryCache) snapshotFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	err = c.Snapshot(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
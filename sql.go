@@ -0,0 +1,307 @@
+package ginche
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// SQLDialect selects the SQL syntax used by SQLAdapter.
+type SQLDialect int
+
+const (
+	// DialectSQLite uses ? placeholders and BLOB values, it needs SQLite 3.24 or newer.
+	DialectSQLite SQLDialect = iota
+	// DialectPostgres uses $n placeholders and BYTEA values.
+	DialectPostgres
+)
+
+// sqlIdentifier matches table names that can be used without quoting
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLOptions is the options for the SQL adapter
+// Table is the name of the table items are stored in, defaults to "ginche_cache"
+// Dialect is the SQL syntax of the database, defaults to DialectSQLite
+type SQLOptions struct {
+	Table   string
+	Dialect SQLDialect
+}
+
+// SQLAdapter stores items as rows of a table in any database/sql database.
+// The table and an index of expiry times are created if they do not exist.
+// Expired rows are ignored by reads and deleted every CleanupInterval.
+// If CacheConfig.Namespace is set, all keys are stored as "<namespace>:<key>",
+// so several caches can share the same table.
+// Compression and integrity checks of CacheConfig apply like for remote adapters.
+type SQLAdapter struct {
+	db      *sql.DB
+	table   string
+	dialect SQLDialect
+	prefix  string
+	ttl     time.Duration
+	codec   *valueCodec
+	queries sqlQueries
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// sqlQueries are the statements of the adapter, built once for its table and dialect.
+type sqlQueries struct {
+	upsert        string
+	get           string
+	delete        string
+	find          string
+	flush         string
+	flushAll      string
+	deleteExpired string
+}
+
+// NewSQLAdapter creates an adapter storing items in db, creating its table if needed.
+// If options is nil, the defaults of SQLOptions are used.
+// If TTL is nil, it will default to 5 minutes, CleanupInterval defaults to 1 minute.
+// The database is not closed with the adapter.
+func NewSQLAdapter(db *sql.DB, options *SQLOptions, config ...CacheConfig) (CacheAdapter, error) {
+	var opts SQLOptions
+	if options != nil {
+		opts = *options
+	}
+	if opts.Table == "" {
+		opts.Table = "ginche_cache"
+	}
+	if !sqlIdentifier.MatchString(opts.Table) {
+		return nil, fmt.Errorf("ginche: invalid table name %q", opts.Table)
+	}
+	var conf CacheConfig
+	if config != nil {
+		conf = config[0]
+	}
+	s := &SQLAdapter{
+		db:      db,
+		table:   opts.Table,
+		dialect: opts.Dialect,
+		ttl:     5 * time.Minute,
+		codec:   newValueCodec(conf),
+		done:    make(chan struct{}),
+	}
+	if conf.TTL != nil {
+		s.ttl = *conf.TTL
+	}
+	if conf.Namespace != "" {
+		s.prefix = conf.Namespace + ":"
+	}
+	s.queries = s.buildQueries()
+	if err := pingWithTimeout(s, conf.PingTimeout); err != nil {
+		return nil, err
+	}
+	if err := s.createTable(context.Background()); err != nil {
+		return nil, err
+	}
+	cleanupInterval := time.Minute
+	if conf.CleanupInterval != nil {
+		cleanupInterval = *conf.CleanupInterval
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.cleanup(cleanupInterval)
+	}()
+	return s, nil
+}
+
+func (s *SQLAdapter) buildQueries() sqlQueries {
+	t := s.table
+	return sqlQueries{
+		upsert: fmt.Sprintf(`INSERT INTO %s (cache_key, value, expires_at) VALUES (%s, %s, %s)
+ON CONFLICT (cache_key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`,
+			t, s.placeholder(1), s.placeholder(2), s.placeholder(3)),
+		get: fmt.Sprintf(`SELECT value, expires_at FROM %s WHERE cache_key = %s AND (expires_at = 0 OR expires_at > %s)`,
+			t, s.placeholder(1), s.placeholder(2)),
+		delete: fmt.Sprintf(`DELETE FROM %s WHERE cache_key = %s`, t, s.placeholder(1)),
+		find: fmt.Sprintf(`SELECT cache_key FROM %s WHERE cache_key LIKE %s ESCAPE '\' AND (expires_at = 0 OR expires_at > %s)`,
+			t, s.placeholder(1), s.placeholder(2)),
+		flush:         fmt.Sprintf(`DELETE FROM %s WHERE substr(cache_key, 1, %s) = %s`, t, s.placeholder(1), s.placeholder(2)),
+		flushAll:      fmt.Sprintf(`DELETE FROM %s`, t),
+		deleteExpired: fmt.Sprintf(`DELETE FROM %s WHERE expires_at > 0 AND expires_at <= %s`, t, s.placeholder(1)),
+	}
+}
+
+// placeholder returns the n-th query parameter, starting at 1.
+func (s *SQLAdapter) placeholder(n int) string {
+	if s.dialect == DialectPostgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+func (s *SQLAdapter) createTable(ctx context.Context) error {
+	blob := "BLOB"
+	if s.dialect == DialectPostgres {
+		blob = "BYTEA"
+	}
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	cache_key TEXT PRIMARY KEY,
+	value %s NOT NULL,
+	expires_at BIGINT NOT NULL
+)`, s.table, blob),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_expires_at ON %s (expires_at)`, s.table, s.table),
+	}
+	for _, statement := range statements {
+		if _, err := s.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set upserts the row of the item. Expiry times are stored in Unix milliseconds, zero if the item does not expire.
+func (s *SQLAdapter) Set(key *string, value interface{}, config ...*ItemConfig) {
+	ttl := s.ttl
+	if config != nil && config[0].TTL != nil {
+		ttl = *config[0].TTL
+	}
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl).UnixMilli()
+	}
+	val, err := json.Marshal(item{Data: value})
+	if err != nil {
+		return
+	}
+	_, _ = s.db.Exec(s.queries.upsert, s.prefix+*key, s.codec.seal(s.prefix+*key, val), expiresAt)
+}
+
+func (s *SQLAdapter) Get(key string) (interface{}, bool) {
+	value, _, ok := s.GetWithTTL(key)
+	return value, ok
+}
+
+// GetWithTTL works like Get and also returns the remaining TTL of the item,
+// zero if it does not expire.
+func (s *SQLAdapter) GetWithTTL(key string) (interface{}, time.Duration, bool) {
+	now := time.Now()
+	var value []byte
+	var expiresAt int64
+	err := s.db.QueryRow(s.queries.get, s.prefix+key, now.UnixMilli()).Scan(&value, &expiresAt)
+	if err != nil {
+		return nil, 0, false
+	}
+	val, err := s.codec.open(s.prefix+key, value)
+	if err == nil {
+		var data item
+		if err = json.Unmarshal(val, &data); err == nil {
+			var ttl time.Duration
+			if expiresAt > 0 {
+				ttl = time.UnixMilli(expiresAt).Sub(now)
+			}
+			return data.Data, ttl, true
+		}
+	}
+	if s.codec.corrupted(key, err) {
+		s.Delete(key)
+	}
+	return nil, 0, false
+}
+
+func (s *SQLAdapter) Delete(key string) {
+	_, _ = s.db.Exec(s.queries.delete, s.prefix+key)
+}
+
+// Find returns keys matching the pattern. The pattern is turned into a LIKE condition
+// selecting candidate rows, which are then matched against the glob.
+func (s *SQLAdapter) Find(pattern string) []string {
+	keys := []string{}
+	rows, err := s.db.Query(s.queries.find, globToLike(escapeGlob(s.prefix)+pattern), time.Now().UnixMilli())
+	if err != nil {
+		return keys
+	}
+	defer rows.Close()
+	g := compileGlob(pattern)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return keys
+		}
+		// LIKE is case-insensitive in some databases, so the namespace is checked too
+		if strings.HasPrefix(key, s.prefix) && g.match(key[len(s.prefix):]) {
+			keys = append(keys, key[len(s.prefix):])
+		}
+	}
+	return keys
+}
+
+// FlushAll deletes all rows of the namespace, or all rows if no namespace is set.
+func (s *SQLAdapter) FlushAll() {
+	if s.prefix == "" {
+		_, _ = s.db.Exec(s.queries.flushAll)
+		return
+	}
+	_, _ = s.db.Exec(s.queries.flush, utf8.RuneCountInString(s.prefix), s.prefix)
+}
+
+// Ping checks the connection to the database.
+func (s *SQLAdapter) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close stops the background expiry and waits for it to return.
+// It is safe to call Close multiple times.
+func (s *SQLAdapter) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	s.wg.Wait()
+	return nil
+}
+
+// cleanup deletes expired rows every interval until the adapter is closed.
+func (s *SQLAdapter) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			_, _ = s.db.Exec(s.queries.deleteExpired, now.UnixMilli())
+		}
+	}
+}
+
+// likeEscape escapes the LIKE wildcards of a literal with backslashes.
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// globToLike returns a LIKE pattern matching at least the keys matched by the glob.
+// Character classes and "?" can not be expressed exactly, they become "%".
+func globToLike(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*', '?':
+			b.WriteByte('%')
+		case '[':
+			b.WriteByte('%')
+			_, n := matchClass(pattern[i:], 0)
+			i += n - 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			b.WriteString(likeEscape(pattern[i : i+1]))
+		default:
+			b.WriteString(likeEscape(string(c)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,195 @@
+package ginche
+
+import (
+	"database/sql"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	_ "modernc.org/sqlite"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type SQLSuite struct {
+	suite.Suite
+	db    *sql.DB
+	store CacheAdapter
+}
+
+func (s *SQLSuite) SetupTest() {
+	var err error
+	s.db, err = sql.Open("sqlite", filepath.Join(s.T().TempDir(), "cache.db")+"?_pragma=busy_timeout(5000)")
+	s.Require().NoError(err)
+	s.store, err = NewSQLAdapter(s.db, nil)
+	s.Require().NoError(err)
+}
+
+func (s *SQLSuite) TearDownTest() {
+	s.NoError(s.store.Close())
+	s.NoError(s.db.Close())
+}
+
+func (s *SQLSuite) count(query string, args ...interface{}) int {
+	var n int
+	s.Require().NoError(s.db.QueryRow(query, args...).Scan(&n))
+	return n
+}
+
+func (s *SQLSuite) TestSet() {
+	key := "test_key"
+	value := "test_value"
+	s.store.Set(&key, value)
+	returnedValue, ok := s.store.Get(key)
+	s.True(ok)
+	s.Equal(value, returnedValue)
+
+	// Upserts replace the row
+	s.store.Set(&key, "other_value")
+	returnedValue, ok = s.store.Get(key)
+	s.True(ok)
+	s.Equal("other_value", returnedValue)
+	s.Equal(1, s.count("SELECT COUNT(*) FROM ginche_cache"))
+
+	s.store.Delete(key)
+	_, ok = s.store.Get(key)
+	s.False(ok)
+	s.Equal(0, s.count("SELECT COUNT(*) FROM ginche_cache"))
+}
+
+func (s *SQLSuite) TestTTL() {
+	s.store.Set(String("short"), "value", &ItemConfig{TTL: Duration(50 * time.Millisecond)})
+	s.store.Set(String("forever"), "value", &ItemConfig{TTL: Duration(0)})
+	_, ttl, ok := s.store.(TTLGetter).GetWithTTL("short")
+	s.True(ok)
+	s.Greater(ttl, time.Duration(0))
+	_, ttl, ok = s.store.(TTLGetter).GetWithTTL("forever")
+	s.True(ok)
+	s.Equal(time.Duration(0), ttl)
+
+	time.Sleep(60 * time.Millisecond)
+	_, ok = s.store.Get("short")
+	s.False(ok)
+	s.Equal([]string{"forever"}, s.store.Find("*"))
+}
+
+func (s *SQLSuite) TestCleanup() {
+	store, err := NewSQLAdapter(s.db, &SQLOptions{Table: "expiring"}, CacheConfig{
+		TTL:             Duration(20 * time.Millisecond),
+		CleanupInterval: Duration(10 * time.Millisecond),
+	})
+	s.Require().NoError(err)
+	defer store.Close()
+	store.Set(String("key"), "value")
+	s.Equal(1, s.count("SELECT COUNT(*) FROM expiring"))
+	s.Eventually(func() bool { return s.count("SELECT COUNT(*) FROM expiring") == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func (s *SQLSuite) TestFind() {
+	for _, key := range []string{"/users/1", "/users/2", "/users/10", "/Users/3", "/users_x", "/percent%", `/back\slash`} {
+		s.store.Set(String(key), key)
+	}
+	tests := []struct {
+		pattern string
+		keys    []string
+	}{
+		{"/users/*", []string{"/users/1", "/users/2", "/users/10"}},
+		{"/users/?", []string{"/users/1", "/users/2"}},
+		{"/users/[12]*", []string{"/users/1", "/users/2", "/users/10"}},
+		{"/users_*", []string{"/users_x"}},
+		{"/percent%", []string{"/percent%"}},
+		{`/back\\slash`, []string{`/back\slash`}},
+		{"/users/1", []string{"/users/1"}},
+		{"/missing", []string{}},
+	}
+	for _, tt := range tests {
+		s.ElementsMatch(tt.keys, s.store.Find(tt.pattern), tt.pattern)
+	}
+}
+
+func (s *SQLSuite) TestNamespaces() {
+	first, _ := NewSQLAdapter(s.db, nil, CacheConfig{Namespace: "first"})
+	defer first.Close()
+	second, _ := NewSQLAdapter(s.db, nil, CacheConfig{Namespace: "FIRST"})
+	defer second.Close()
+	first.Set(String("key"), "first")
+	second.Set(String("key"), "second")
+
+	d, ok := first.Get("key")
+	s.True(ok)
+	s.Equal("first", d)
+	s.Equal([]string{"key"}, second.Find("*"))
+
+	first.FlushAll()
+	s.Empty(first.Find("*"))
+	d, ok = second.Get("key")
+	s.True(ok)
+	s.Equal("second", d)
+	s.store.FlushAll()
+	s.Equal(0, s.count("SELECT COUNT(*) FROM ginche_cache"))
+}
+
+func (s *SQLSuite) TestIntegrity() {
+	var reported []string
+	store, err := NewSQLAdapter(s.db, nil, CacheConfig{
+		IntegritySecret:  []byte("secret"),
+		Compression:      CompressionGzip,
+		OnIntegrityError: func(key string, err error) { reported = append(reported, key) },
+	})
+	s.Require().NoError(err)
+	defer store.Close()
+	store.Set(String("key"), "value")
+	d, ok := store.Get("key")
+	s.True(ok)
+	s.Equal("value", d)
+
+	_, err = s.db.Exec("UPDATE ginche_cache SET value = ? WHERE cache_key = ?", []byte(`{"Data":"evil"}`), "key")
+	s.Require().NoError(err)
+	_, ok = store.Get("key")
+	s.False(ok)
+	s.Equal([]string{"key"}, reported)
+	s.Equal(0, s.count("SELECT COUNT(*) FROM ginche_cache"))
+}
+
+func (s *SQLSuite) TestWithMiddleware() {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware(s.store, nil))
+	r.GET("/test", func(ctx *gin.Context) {
+		ctx.String(http.StatusAccepted, "test")
+	})
+	for _, cache := range []string{HeaderXCacheMiss, HeaderXCacheHit} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/test", nil)
+		r.ServeHTTP(w, req)
+		s.Equal(cache, w.Header().Get(HeaderXCache))
+		s.Equal(http.StatusAccepted, w.Code)
+		s.Equal("test", w.Body.String())
+	}
+}
+
+func (s *SQLSuite) TestInvalidTable() {
+	_, err := NewSQLAdapter(s.db, &SQLOptions{Table: "cache; DROP TABLE users"})
+	s.Error(err)
+}
+
+func TestSQLSuite(t *testing.T) {
+	suite.Run(t, new(SQLSuite))
+}
+
+func TestGlobToLike(t *testing.T) {
+	tests := map[string]string{
+		"/users/*":    "/users/%",
+		"/users/?":    "/users/%",
+		"/[a-z]x":     "/%x",
+		"/[^a]x":      "/%x",
+		`/a\*b`:       "/a*b",
+		"/100%_done":  `/100\%\_done`,
+		`/back\\path`: `/back\\path`,
+	}
+	for pattern, like := range tests {
+		assert.Equal(t, like, globToLike(pattern), pattern)
+	}
+}
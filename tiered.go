@@ -0,0 +1,254 @@
+package ginche
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrScanNotSupported is returned by Scan when the underlying adapter does not implement Scanner.
+var ErrScanNotSupported = errors.New("ginche: adapter does not support Scan")
+
+// WriteMode defines how TieredAdapter writes items.
+type WriteMode int
+
+const (
+	// WriteThrough writes items to both L1 and L2.
+	WriteThrough WriteMode = iota
+	// WriteAround writes items to L2 only and drops them from L1,
+	// they get to L1 on the next read.
+	WriteAround
+)
+
+// TieredOptions is the options for the tiered adapter
+// WriteMode is the way items are written, it defaults to WriteThrough
+// L1TTL caps the TTL of items kept in L1, so they are refreshed from L2 more often
+// DisablePromotion disables copying items found in L2 to L1
+// OnInvalidate is called after a key is set or deleted, e.g. to tell other instances to drop it from their L1
+// OnFlush is called after FlushAll
+type TieredOptions struct {
+	WriteMode        WriteMode
+	L1TTL            *time.Duration
+	DisablePromotion bool
+	OnInvalidate     func(key string)
+	OnFlush          func()
+}
+
+// TieredAdapter composes two adapters into a two-level cache.
+// Reads go to L1 first and fall back to L2, L2 is the source of truth.
+// Tiers can be nested to build deeper hierarchies, e.g. memory -> disk -> Redis.
+type TieredAdapter struct {
+	l1      CacheAdapter
+	l2      CacheAdapter
+	options TieredOptions
+}
+
+// NewTieredAdapter creates an adapter reading from l1 first and falling back to l2.
+// If options is nil, it writes through both tiers and promotes L2 hits to L1.
+// The adapter takes ownership of both tiers and closes them on Close.
+func NewTieredAdapter(l1, l2 CacheAdapter, options *TieredOptions) *TieredAdapter {
+	t := &TieredAdapter{l1: l1, l2: l2}
+	if options != nil {
+		t.options = *options
+	}
+	return t
+}
+
+// Set writes the item to L2 and, in WriteThrough mode, to L1.
+func (t *TieredAdapter) Set(key *string, value interface{}, config ...*ItemConfig) {
+	t.l2.Set(key, value, config...)
+	if t.options.WriteMode == WriteAround {
+		t.l1.Delete(*key)
+	} else {
+		t.setL1(key, value, config...)
+	}
+	if t.options.OnInvalidate != nil {
+		t.options.OnInvalidate(*key)
+	}
+}
+
+// setL1 writes the item to L1 with its TTL capped by L1TTL.
+// A TTL of 0 means no expiry in L2, so L1 keeps the item for L1TTL or its own default TTL.
+func (t *TieredAdapter) setL1(key *string, value interface{}, config ...*ItemConfig) {
+	var ttl *time.Duration
+	if config != nil && config[0].TTL != nil && *config[0].TTL > 0 {
+		ttl = config[0].TTL
+	}
+	if t.options.L1TTL != nil && (ttl == nil || *ttl > *t.options.L1TTL) {
+		ttl = t.options.L1TTL
+	}
+	if ttl == nil {
+		t.l1.Set(key, value)
+		return
+	}
+	t.l1.Set(key, value, &ItemConfig{TTL: ttl})
+}
+
+// Get returns the item from L1 or, if it is missing there, from L2.
+// Items found in L2 are promoted to L1 for their remaining TTL, if L2 reports it.
+func (t *TieredAdapter) Get(key string) (interface{}, bool) {
+	if value, ok := t.l1.Get(key); ok {
+		return value, true
+	}
+	var (
+		value interface{}
+		ttl   time.Duration
+		ok    bool
+	)
+	if getter, isGetter := t.l2.(TTLGetter); isGetter {
+		value, ttl, ok = getter.GetWithTTL(key)
+	} else {
+		value, ok = t.l2.Get(key)
+	}
+	if !ok {
+		return nil, false
+	}
+	if !t.options.DisablePromotion {
+		if ttl > 0 {
+			t.setL1(&key, value, &ItemConfig{TTL: &ttl})
+		} else {
+			t.setL1(&key, value)
+		}
+	}
+	return value, true
+}
+
+// GetMulti returns the items found in L1 and fetches the missing ones from L2 in a single batch.
+// Items found in L2 are promoted to L1 like in Get.
+func (t *TieredAdapter) GetMulti(keys []string) map[string]interface{} {
+	values := GetMulti(t.l1, keys)
+	misses := make([]string, 0, len(keys)-len(values))
+	for _, key := range keys {
+		if _, ok := values[key]; !ok {
+			misses = append(misses, key)
+		}
+	}
+	if len(misses) == 0 {
+		return values
+	}
+	var entries map[string]Entry
+	if getter, ok := t.l2.(BatchTTLGetter); ok {
+		entries = getter.GetMultiWithTTL(misses)
+	} else {
+		found := GetMulti(t.l2, misses)
+		entries = make(map[string]Entry, len(found))
+		for key, value := range found {
+			entries[key] = Entry{Value: value}
+		}
+	}
+	for key, entry := range entries {
+		values[key] = entry.Value
+		if t.options.DisablePromotion {
+			continue
+		}
+		key := key
+		if entry.TTL > 0 {
+			t.setL1(&key, entry.Value, &ItemConfig{TTL: &entry.TTL})
+		} else {
+			t.setL1(&key, entry.Value)
+		}
+	}
+	return values
+}
+
+// SetMulti writes the items to L2 and, in WriteThrough mode, to L1, in batches.
+func (t *TieredAdapter) SetMulti(items map[string]interface{}, config ...*ItemConfig) {
+	SetMulti(t.l2, items, config...)
+	if t.options.WriteMode == WriteAround {
+		keys := make([]string, 0, len(items))
+		for key := range items {
+			keys = append(keys, key)
+		}
+		DeleteMulti(t.l1, keys)
+	} else {
+		for key, value := range items {
+			key := key
+			t.setL1(&key, value, config...)
+		}
+	}
+	if t.options.OnInvalidate != nil {
+		for key := range items {
+			t.options.OnInvalidate(key)
+		}
+	}
+}
+
+// DeleteMulti deletes the items from both tiers in batches.
+func (t *TieredAdapter) DeleteMulti(keys []string) {
+	DeleteMulti(t.l2, keys)
+	DeleteMulti(t.l1, keys)
+	if t.options.OnInvalidate != nil {
+		for _, key := range keys {
+			t.options.OnInvalidate(key)
+		}
+	}
+}
+
+// Delete deletes the item from both tiers.
+func (t *TieredAdapter) Delete(key string) {
+	t.l2.Delete(key)
+	t.l1.Delete(key)
+	if t.options.OnInvalidate != nil {
+		t.options.OnInvalidate(key)
+	}
+}
+
+// Find returns keys matching the pattern in L2.
+func (t *TieredAdapter) Find(pattern string) []string {
+	return t.l2.Find(pattern)
+}
+
+// Scan walks the keys of L2 if it implements Scanner.
+func (t *TieredAdapter) Scan(ctx context.Context, pattern string, cursor uint64, count int64) ([]string, uint64, error) {
+	scanner, ok := t.l2.(Scanner)
+	if !ok {
+		return nil, 0, ErrScanNotSupported
+	}
+	return scanner.Scan(ctx, pattern, cursor, count)
+}
+
+// FlushAll deletes all items from both tiers.
+func (t *TieredAdapter) FlushAll() {
+	t.l2.FlushAll()
+	t.l1.FlushAll()
+	if t.options.OnFlush != nil {
+		t.options.OnFlush()
+	}
+}
+
+// Invalidate drops the key from L1 only.
+// Use it to apply invalidations received from other instances.
+func (t *TieredAdapter) Invalidate(key string) {
+	t.l1.Delete(key)
+}
+
+// InvalidateAll drops all items from L1 only.
+func (t *TieredAdapter) InvalidateAll() {
+	t.l1.FlushAll()
+}
+
+// Ping checks both tiers that implement Pinger.
+func (t *TieredAdapter) Ping(ctx context.Context) error {
+	if p, ok := t.l1.(Pinger); ok {
+		if err := p.Ping(ctx); err != nil {
+			return fmt.Errorf("l1: %w", err)
+		}
+	}
+	if p, ok := t.l2.(Pinger); ok {
+		if err := p.Ping(ctx); err != nil {
+			return fmt.Errorf("l2: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close closes both tiers and returns the first error.
+func (t *TieredAdapter) Close() error {
+	err1 := t.l1.Close()
+	err2 := t.l2.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
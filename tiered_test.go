@@ -0,0 +1,207 @@
+package ginche
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type TieredSuite struct {
+	suite.Suite
+	l1 *InMemoryCache
+	l2 *InMemoryCache
+}
+
+func (s *TieredSuite) SetupTest() {
+	s.l1 = NewInMemoryCache().(*InMemoryCache)
+	s.l2 = NewInMemoryCache(CacheConfig{TTL: Duration(time.Hour)}).(*InMemoryCache)
+}
+
+func (s *TieredSuite) TestWriteThrough() {
+	store := NewTieredAdapter(s.l1, s.l2, nil)
+	defer store.Close()
+	store.Set(String("key"), "value")
+
+	d, ok := s.l1.Get("key")
+	s.True(ok)
+	s.Equal("value", d)
+	d, ok = s.l2.Get("key")
+	s.True(ok)
+	s.Equal("value", d)
+}
+
+func (s *TieredSuite) TestWriteAround() {
+	store := NewTieredAdapter(s.l1, s.l2, &TieredOptions{WriteMode: WriteAround})
+	defer store.Close()
+	s.l1.Set(String("key"), "stale")
+	store.Set(String("key"), "value")
+
+	_, ok := s.l1.Get("key")
+	s.False(ok)
+	d, ok := store.Get("key")
+	s.True(ok)
+	s.Equal("value", d)
+	// The L2 hit was promoted to L1
+	d, ok = s.l1.Get("key")
+	s.True(ok)
+	s.Equal("value", d)
+}
+
+func (s *TieredSuite) TestPromotionKeepsRemainingTTL() {
+	store := NewTieredAdapter(s.l1, s.l2, nil)
+	defer store.Close()
+	s.l2.Set(String("key"), "value", &ItemConfig{TTL: Duration(2 * time.Second)})
+
+	_, ok := store.Get("key")
+	s.True(ok)
+	_, ttl, ok := s.l1.GetWithTTL("key")
+	s.True(ok)
+	s.LessOrEqual(ttl, 2*time.Second)
+	s.Greater(ttl, time.Second)
+}
+
+func (s *TieredSuite) TestL1TTLCap() {
+	store := NewTieredAdapter(s.l1, s.l2, &TieredOptions{L1TTL: Duration(time.Second)})
+	defer store.Close()
+	store.Set(String("key"), "value")
+
+	_, ttl, ok := s.l1.GetWithTTL("key")
+	s.True(ok)
+	s.LessOrEqual(ttl, time.Second)
+	_, ttl, ok = s.l2.GetWithTTL("key")
+	s.True(ok)
+	s.Greater(ttl, time.Minute)
+
+	// Promotion is capped as well
+	s.l1.Delete("key")
+	_, ok = store.Get("key")
+	s.True(ok)
+	_, ttl, ok = s.l1.GetWithTTL("key")
+	s.True(ok)
+	s.LessOrEqual(ttl, time.Second)
+}
+
+func (s *TieredSuite) TestWithoutExpiry() {
+	l2, err := NewDiskAdapter(s.T().TempDir())
+	s.Require().NoError(err)
+	store := NewTieredAdapter(s.l1, l2, &TieredOptions{L1TTL: Duration(time.Second)})
+	defer store.Close()
+	store.Set(String("key"), "value", &ItemConfig{TTL: Duration(0)})
+
+	_, ttl, ok := s.l1.GetWithTTL("key")
+	s.True(ok, "items without expiry are kept in L1")
+	s.LessOrEqual(ttl, time.Second)
+	s.Greater(ttl, time.Duration(0))
+	_, ttl, ok = l2.(TTLGetter).GetWithTTL("key")
+	s.True(ok)
+	s.Zero(ttl)
+
+	// Without L1TTL, L1 uses its default TTL
+	other := NewTieredAdapter(NewInMemoryCache(), NewInMemoryCache(), nil)
+	defer other.Close()
+	other.Set(String("key"), "value", &ItemConfig{TTL: Duration(0)})
+	_, ttl, ok = other.l1.(*InMemoryCache).GetWithTTL("key")
+	s.True(ok)
+	s.Greater(ttl, 50*time.Second)
+}
+
+func (s *TieredSuite) TestDisablePromotion() {
+	store := NewTieredAdapter(s.l1, s.l2, &TieredOptions{DisablePromotion: true})
+	defer store.Close()
+	s.l2.Set(String("key"), "value")
+
+	_, ok := store.Get("key")
+	s.True(ok)
+	_, ok = s.l1.Get("key")
+	s.False(ok)
+}
+
+func (s *TieredSuite) TestInvalidationHooks() {
+	var invalidated []string
+	flushes := 0
+	store := NewTieredAdapter(s.l1, s.l2, &TieredOptions{
+		OnInvalidate: func(key string) {
+			invalidated = append(invalidated, key)
+		},
+		OnFlush: func() {
+			flushes++
+		},
+	})
+	defer store.Close()
+	store.Set(String("a"), 1)
+	store.Delete("a")
+	store.FlushAll()
+	s.Equal([]string{"a", "a"}, invalidated)
+	s.Equal(1, flushes)
+
+	store.Set(String("b"), 2)
+	store.Invalidate("b")
+	_, ok := s.l1.Get("b")
+	s.False(ok)
+	_, ok = store.Get("b")
+	s.True(ok)
+	store.InvalidateAll()
+	s.Equal(0, s.l1.Len())
+	s.Equal(1, s.l2.Len())
+}
+
+func (s *TieredSuite) TestFindAndScan() {
+	store := NewTieredAdapter(s.l1, s.l2, &TieredOptions{WriteMode: WriteAround})
+	defer store.Close()
+	store.Set(String("/users/1"), 1)
+	s.Equal([]string{"/users/1"}, store.Find("/users/*"))
+	keys, next, err := store.Scan(context.Background(), "/users/*", 0, 100)
+	s.NoError(err)
+	s.Equal(uint64(0), next)
+	s.Equal([]string{"/users/1"}, keys)
+
+	// Hide the Scan method of L2
+	noScan := NewTieredAdapter(s.l1, struct{ CacheAdapter }{s.l2}, nil)
+	_, _, err = noScan.Scan(context.Background(), "*", 0, 100)
+	s.ErrorIs(err, ErrScanNotSupported)
+}
+
+func (s *TieredSuite) TestRedisL2() {
+	mRedis := miniredis.NewMiniRedis()
+	s.NoError(mRedis.Start())
+	defer mRedis.Close()
+	l2, err := NewRedisAdapter(&redis.Options{Addr: mRedis.Addr()})
+	s.NoError(err)
+	store := NewTieredAdapter(s.l1, l2, &TieredOptions{L1TTL: Duration(time.Second)})
+	defer store.Close()
+
+	store.Set(String("key"), "value", &ItemConfig{TTL: Duration(time.Minute)})
+	s.Equal(time.Minute, mRedis.TTL("key"))
+	s.l1.FlushAll()
+	d, ok := store.Get("key")
+	s.True(ok)
+	s.Equal("value", d)
+	_, ttl, ok := s.l1.GetWithTTL("key")
+	s.True(ok)
+	s.LessOrEqual(ttl, time.Second)
+}
+
+func (s *TieredSuite) TestMulti() {
+	store := NewTieredAdapter(s.l1, s.l2, &TieredOptions{WriteMode: WriteAround})
+	defer store.Close()
+	store.SetMulti(map[string]interface{}{"a": 1, "b": 2, "c": 3})
+	s.Empty(s.l1.GetMulti([]string{"a", "b", "c"}))
+
+	s.l1.Set(String("a"), "l1")
+	values := GetMulti(store, []string{"a", "b", "missing"})
+	s.Equal(map[string]interface{}{"a": "l1", "b": 2}, values)
+	// L2 hits are promoted with their remaining TTL
+	_, ttl, ok := s.l1.GetWithTTL("b")
+	s.True(ok)
+	s.Greater(ttl, time.Minute)
+
+	DeleteMulti(store, []string{"a", "b"})
+	s.Equal(map[string]interface{}{"c": 3}, store.GetMulti([]string{"a", "b", "c"}))
+}
+
+func TestTieredSuite(t *testing.T) {
+	suite.Run(t, new(TieredSuite))
+}
@@ -0,0 +1,247 @@
+package ginche
+
+const (
+	// tinyLFUWindowPercent is the share of items kept in the admission window.
+	tinyLFUWindowPercent = 1
+	// tinyLFUProtectedPercent is the share of the main cache kept in the protected segment.
+	tinyLFUProtectedPercent = 80
+	// tinyLFUEstimatedItemBytes is the item size assumed to size the sketch of caches limited by bytes only.
+	tinyLFUEstimatedItemBytes = 4 << 10
+	// sketchDepth is the number of counter rows in the count-min sketch.
+	sketchDepth = 4
+	// sketchMaxCount is the saturation value of a sketch counter.
+	sketchMaxCount = 15
+)
+
+// tinyLFUPolicy implements W-TinyLFU.
+// New items enter the window LRU. Items pushed out of the window become
+// candidates at the head of the probation segment of the main SLRU.
+// When an item has to be evicted, a candidate competes with the probation
+// tail and the one with the lower estimated frequency loses.
+// Items hit while in probation are promoted to the protected segment.
+// Segment sizes are relative to the number of tracked items, so the policy
+// works the same for entry and byte limits.
+type tinyLFUPolicy struct {
+	window    itemList
+	probation itemList
+	protected itemList
+	sketch    *countMinSketch
+}
+
+// newTinyLFUPolicy creates a policy with a sketch of about width counters per row,
+// which should be the number of items the shard can hold.
+func newTinyLFUPolicy(width int) *tinyLFUPolicy {
+	p := &tinyLFUPolicy{sketch: newCountMinSketch(width)}
+	p.window.init()
+	p.probation.init()
+	p.protected.init()
+	return p
+}
+
+// tinyLFUWidth returns the sketch width of a shard: its share of MaxEntries, or its share of MaxBytes
+// divided by tinyLFUEstimatedItemBytes if only MaxBytes is set. It returns 0 if the cache has no limit.
+func tinyLFUWidth(conf CacheConfig, shards int) int {
+	if conf.MaxEntries > 0 {
+		return int(ceilDiv(int64(conf.MaxEntries), int64(shards)))
+	}
+	if conf.MaxBytes > 0 {
+		return int(ceilDiv(conf.MaxBytes, int64(shards)*tinyLFUEstimatedItemBytes))
+	}
+	return 0
+}
+
+func (p *tinyLFUPolicy) add(item *Item) {
+	item.segment = segmentWindow
+	p.window.pushFront(item)
+
+	total := p.window.len + p.probation.len + p.protected.len
+	for p.window.len > 1 && p.window.len*100 > total*tinyLFUWindowPercent {
+		candidate := p.window.back()
+		p.window.remove(candidate)
+		candidate.segment = segmentProbation
+		candidate.candidate = true
+		p.probation.pushFront(candidate)
+	}
+}
+
+func (p *tinyLFUPolicy) access(item *Item) {
+	switch item.segment {
+	case segmentWindow:
+		p.window.moveToFront(item)
+	case segmentProbation:
+		p.probation.remove(item)
+		item.segment = segmentProtected
+		item.candidate = false
+		p.protected.pushFront(item)
+		p.demoteProtected()
+	case segmentProtected:
+		p.protected.moveToFront(item)
+	}
+}
+
+// demoteProtected moves items from the protected tail to probation
+// until the protected segment fits its share of the main cache.
+func (p *tinyLFUPolicy) demoteProtected() {
+	main := p.probation.len + p.protected.len
+	for p.protected.len*100 > main*tinyLFUProtectedPercent {
+		item := p.protected.back()
+		p.protected.remove(item)
+		item.segment = segmentProbation
+		p.probation.pushFront(item)
+	}
+}
+
+func (p *tinyLFUPolicy) remove(item *Item) {
+	switch item.segment {
+	case segmentWindow:
+		p.window.remove(item)
+	case segmentProbation:
+		p.probation.remove(item)
+	case segmentProtected:
+		p.protected.remove(item)
+	}
+}
+
+func (p *tinyLFUPolicy) victim() *Item {
+	victim := p.probation.back()
+	if victim == nil {
+		victim = p.protected.back()
+	}
+	if victim == nil {
+		victim = p.window.back()
+	}
+	if victim == nil || victim.segment != segmentProbation {
+		return victim
+	}
+
+	candidate := p.probation.front()
+	if candidate == victim || !candidate.candidate {
+		return victim
+	}
+	if p.sketch.estimate(candidate.key) > p.sketch.estimate(victim.key) {
+		candidate.candidate = false
+		return victim
+	}
+	return candidate
+}
+
+func (p *tinyLFUPolicy) record(key string) {
+	p.sketch.increment(key)
+}
+
+func (p *tinyLFUPolicy) reset() {
+	p.window.init()
+	p.probation.init()
+	p.protected.init()
+	p.sketch.clear()
+}
+
+// countMinSketch estimates access frequencies of keys with saturating counters.
+// A doorkeeper bloom filter absorbs the first access of every key, so
+// keys seen only once never reach the counters. After sampleSize increments
+// all counters are halved and the doorkeeper is cleared, so old popularity fades.
+type countMinSketch struct {
+	rows       [sketchDepth][]uint8
+	doorkeeper []uint64
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+func newCountMinSketch(width int) *countMinSketch {
+	w := 64
+	for w < width {
+		w <<= 1
+	}
+	s := &countMinSketch{
+		doorkeeper: make([]uint64, w/64),
+		mask:       uint64(w - 1),
+		sampleSize: 10 * w,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+// increment records an access of the key.
+func (s *countMinSketch) increment(key string) {
+	h1, h2 := hashKey(key)
+	if !s.inDoorkeeper(h1, h2) {
+		s.addToDoorkeeper(h1, h2)
+	} else {
+		for i := range s.rows {
+			idx := (h1 + uint64(i)*h2) & s.mask
+			if s.rows[i][idx] < sketchMaxCount {
+				s.rows[i][idx]++
+			}
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.age()
+	}
+}
+
+// estimate returns the estimated access frequency of the key.
+func (s *countMinSketch) estimate(key string) int {
+	h1, h2 := hashKey(key)
+	count := uint8(sketchMaxCount)
+	for i := range s.rows {
+		if c := s.rows[i][(h1+uint64(i)*h2)&s.mask]; c < count {
+			count = c
+		}
+	}
+	if s.inDoorkeeper(h1, h2) {
+		return int(count) + 1
+	}
+	return int(count)
+}
+
+// age halves all counters and clears the doorkeeper.
+func (s *countMinSketch) age() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	for i := range s.doorkeeper {
+		s.doorkeeper[i] = 0
+	}
+	s.additions /= 2
+}
+
+// clear resets all counters and the doorkeeper.
+func (s *countMinSketch) clear() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] = 0
+		}
+	}
+	for i := range s.doorkeeper {
+		s.doorkeeper[i] = 0
+	}
+	s.additions = 0
+}
+
+func (s *countMinSketch) inDoorkeeper(h1, h2 uint64) bool {
+	a, b := h1&s.mask, (h1+h2)&s.mask
+	return s.doorkeeper[a/64]&(1<<(a%64)) != 0 && s.doorkeeper[b/64]&(1<<(b%64)) != 0
+}
+
+func (s *countMinSketch) addToDoorkeeper(h1, h2 uint64) {
+	a, b := h1&s.mask, (h1+h2)&s.mask
+	s.doorkeeper[a/64] |= 1 << (a % 64)
+	s.doorkeeper[b/64] |= 1 << (b % 64)
+}
+
+// hashKey returns two independent hashes of the key for double hashing.
+// It is FNV-1a, inlined to avoid allocations.
+func hashKey(key string) (uint64, uint64) {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h, (h>>32 | h<<32) | 1
+}
@@ -0,0 +1,137 @@
+package ginche
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCountMinSketch(t *testing.T) {
+	s := newCountMinSketch(64)
+	if got := s.estimate("key"); got != 0 {
+		t.Errorf("estimate() = %v, want 0", got)
+	}
+	// The first increment only lands in the doorkeeper
+	s.increment("key")
+	if got := s.estimate("key"); got != 1 {
+		t.Errorf("estimate() = %v, want 1", got)
+	}
+	for i := 0; i < 20; i++ {
+		s.increment("key")
+	}
+	if got := s.estimate("key"); got != sketchMaxCount+1 {
+		t.Errorf("estimate() = %v, want %v", got, sketchMaxCount+1)
+	}
+	if got := s.estimate("other"); got > 1 {
+		t.Errorf("estimate() = %v, want at most 1", got)
+	}
+}
+
+func TestCountMinSketchAging(t *testing.T) {
+	s := newCountMinSketch(64)
+	for i := 0; i < 9; i++ {
+		s.increment("key")
+	}
+	s.age()
+	if got := s.estimate("key"); got != 4 {
+		t.Errorf("estimate() = %v, want 4", got)
+	}
+}
+
+// hitRatio replays a workload of a hot key set interleaved with a scan of
+// unique keys and returns the hit ratio of the hot keys after the scan.
+func hitRatio(policy EvictionPolicy) float64 {
+	cache := NewInMemoryCache(CacheConfig{MaxEntries: 100, EvictionPolicy: policy})
+	hot := 80
+	access := func(key string) {
+		if _, ok := cache.Get(key); !ok {
+			cache.Set(&key, key)
+		}
+	}
+	for round := 0; round < 10; round++ {
+		for i := 0; i < hot; i++ {
+			access(fmt.Sprintf("hot%d", i))
+		}
+	}
+	for i := 0; i < 10000; i++ {
+		access(fmt.Sprintf("scan%d", i))
+		access(fmt.Sprintf("hot%d", i%hot))
+	}
+	hits := 0
+	for i := 0; i < hot; i++ {
+		if _, ok := cache.Get(fmt.Sprintf("hot%d", i)); ok {
+			hits++
+		}
+	}
+	return float64(hits) / float64(hot)
+}
+
+func TestTinyLFUResistsScans(t *testing.T) {
+	lru := hitRatio(EvictionLRU)
+	tinyLFU := hitRatio(EvictionTinyLFU)
+	if tinyLFU < 0.9 {
+		t.Errorf("TinyLFU hot hit ratio = %v, want at least 0.9", tinyLFU)
+	}
+	if tinyLFU <= lru {
+		t.Errorf("TinyLFU hot hit ratio = %v, want more than LRU %v", tinyLFU, lru)
+	}
+}
+
+func TestTinyLFUEvictsWithinLimit(t *testing.T) {
+	evictions := 0
+	cache := NewInMemoryCache(CacheConfig{
+		MaxEntries:     10,
+		EvictionPolicy: EvictionTinyLFU,
+		OnEvict: func(key string, value interface{}) {
+			evictions++
+		},
+	})
+	for i := 0; i < 100; i++ {
+		cache.Set(String(fmt.Sprintf("key%d", i)), i)
+	}
+	if got := cache.(*InMemoryCache).Len(); got != 10 {
+		t.Errorf("len(items) = %v, want 10", got)
+	}
+	if evictions != 90 {
+		t.Errorf("evictions = %v, want 90", evictions)
+	}
+	cache.FlushAll()
+	cache.Set(String("key"), 1)
+	if d, ok := cache.Get("key"); !ok || d != 1 {
+		t.Errorf("Get() = %v, %v, want 1, true", d, ok)
+	}
+}
+
+func TestTinyLFUSketchSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		conf  CacheConfig
+		width int
+	}{
+		{"entries", CacheConfig{MaxEntries: 10000, Shards: 8}, 2048},
+		{"bytes", CacheConfig{MaxBytes: 64 << 20, Shards: 8}, 2048},
+		{"unbounded", CacheConfig{Shards: 8}, 0},
+		{"item limit only", CacheConfig{MaxItemBytes: 1 << 20, Shards: 8}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.conf.EvictionPolicy = EvictionTinyLFU
+			cache := NewInMemoryCache(tt.conf).(*InMemoryCache)
+			defer cache.Close()
+			for _, s := range cache.shards {
+				p, ok := s.policy.(*tinyLFUPolicy)
+				if tt.width == 0 {
+					if ok {
+						t.Fatalf("policy = TinyLFU, want LRU without limits")
+					}
+					continue
+				}
+				if !ok {
+					t.Fatalf("policy = %T, want TinyLFU", s.policy)
+				}
+				if got := len(p.sketch.rows[0]); got != tt.width {
+					t.Errorf("sketch width = %v, want %v", got, tt.width)
+				}
+			}
+		})
+	}
+}